@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bjarke-xyz/go-monorepo/libs/common/config"
@@ -13,7 +15,17 @@ import (
 
 type RedisCache struct {
 	cache     *cache.Cache
+	rdb       *redis.Client
 	keyPrefix string
+
+	statsMu sync.Mutex
+	stats   map[string]*CacheStats
+}
+
+// CacheStats are the number of hits and misses of Get
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
 }
 
 func NewRedisCache(cfg *config.Config) *RedisCache {
@@ -27,7 +39,9 @@ func NewRedisCache(cfg *config.Config) *RedisCache {
 	})
 	return &RedisCache{
 		cache:     mycache,
+		rdb:       rdb,
 		keyPrefix: cfg.RedisPrefix,
+		stats:     make(map[string]*CacheStats),
 	}
 }
 
@@ -50,6 +64,7 @@ func (r *RedisCache) Set(ctx context.Context, key string, value any, TTL time.Du
 
 func (r *RedisCache) Get(ctx context.Context, key string, value any) error {
 	err := r.cache.Get(ctx, r.getKey(key), value)
+	r.count(key, err == nil)
 	if err != nil {
 		if !errors.Is(err, cache.ErrCacheMiss) {
 			log.Printf("cache get with key %v failed: %v", key, err)
@@ -58,6 +73,55 @@ func (r *RedisCache) Get(ctx context.Context, key string, value any) error {
 	return err
 }
 
+// count counts a hit or miss for the name of the key, which is the part before the first colon
+func (r *RedisCache) count(key string, hit bool) {
+	name, _, _ := strings.Cut(key, ":")
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	stats, ok := r.stats[name]
+	if !ok {
+		stats = &CacheStats{}
+		r.stats[name] = stats
+	}
+	if hit {
+		stats.Hits++
+	} else {
+		stats.Misses++
+	}
+}
+
+// Stats returns the hits and misses of this instance since it started, by the name of the keys
+func (r *RedisCache) Stats() map[string]CacheStats {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	stats := make(map[string]CacheStats, len(r.stats))
+	for name, s := range r.stats {
+		stats[name] = *s
+	}
+	return stats
+}
+
+// Incr increments the integer at key, shared by all instances, and returns the new value
+func (r *RedisCache) Incr(ctx context.Context, key string) (int64, error) {
+	value, err := r.rdb.Incr(ctx, r.getKey(key)).Result()
+	if err != nil {
+		log.Printf("incr with key %v failed: %v", key, err)
+	}
+	return value, err
+}
+
+// GetInt returns the integer at key, or 0 if it is not set. It is not cached locally, so it is the same on all instances.
+func (r *RedisCache) GetInt(ctx context.Context, key string) (int64, error) {
+	value, err := r.rdb.Get(ctx, r.getKey(key)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		log.Printf("get int with key %v failed: %v", key, err)
+	}
+	return value, err
+}
+
 func (r *RedisCache) Delete(ctx context.Context, key string) error {
 	err := r.cache.Delete(ctx, r.getKey(key))
 	if err != nil {
@@ -67,3 +131,40 @@ func (r *RedisCache) Delete(ctx context.Context, key string) error {
 	}
 	return err
 }
+
+// Publish sends the message to everyone subscribed to the channel, on any instance
+func (r *RedisCache) Publish(ctx context.Context, channel string, message string) error {
+	err := r.rdb.Publish(ctx, r.getKey(channel), message).Err()
+	if err != nil {
+		log.Printf("publish to channel %v failed: %v", channel, err)
+	}
+	return err
+}
+
+// Subscribe returns the messages published to the channel, until ctx is done.
+// The returned channel is closed when the subscription ends.
+func (r *RedisCache) Subscribe(ctx context.Context, channel string) <-chan string {
+	messages := make(chan string)
+	pubsub := r.rdb.Subscribe(ctx, r.getKey(channel))
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+		redisMessages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-redisMessages:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- message.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages
+}
@@ -87,3 +87,26 @@ func (s *StorageClient) Get(ctx context.Context, bucket string, key string) ([]b
 	}
 	return body, nil
 }
+
+// List returns the keys in the bucket that start with prefix
+func (s *StorageClient) List(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	client, err := s.newClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+	keys := make([]string, 0)
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing objects in bucket %v with prefix %v: %w", bucket, prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	return keys, nil
+}
@@ -4,6 +4,7 @@ type AppContext struct {
 	PriceRepository *PriceRepository
 	Storage         *StorageClient
 	JobManager      *JobManager
+	OkClient        *OkClient
 }
 
 func NewAppContext(config *Config) *AppContext {
@@ -11,5 +12,6 @@ func NewAppContext(config *Config) *AppContext {
 		PriceRepository: NewPriceRepository(config),
 		Storage:         NewStorageClient(config),
 		JobManager:      NewJobManager(),
+		OkClient:        NewOkClient(OkBaseUrl),
 	}
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const cliUsage = `Usage: fuelpricesapi [command] [flags]
+
+Without a command the http server is started.
+
+Commands:
+  fetch     fetch prices from ok.dk and store the response in R2
+  process   process the latest stored ok.dk response
+  replay    process a daily snapshot of the ok.dk response stored in R2
+  backfill  fetch the full history from ok.dk and process a date range
+
+Run 'fuelpricesapi [command] -h' for the flags of a command.
+`
+
+// runCli runs the command given in args. The returned bool is false if args does not contain a command.
+func runCli(appContext *AppContext, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	command := args[0]
+	job := NewFetchOkDataJob(appContext)
+	switch command {
+	case "fetch":
+		return true, runFetchCommand(job, args[1:])
+	case "process":
+		return true, runProcessCommand(job, args[1:])
+	case "replay":
+		return true, runReplayCommand(job, args[1:])
+	case "backfill":
+		return true, runBackfillCommand(job, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return true, nil
+	default:
+		return true, fmt.Errorf("unknown command %q\n%v", command, cliUsage)
+	}
+}
+
+func runFetchCommand(job *FetchOkDataJob, args []string) error {
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	typesFlag := flags.String("types", "all", "comma separated fuel types: unleaded95, octane100, diesel or all")
+	flags.Parse(args)
+	fuelTypes, err := parseFuelTypes(*typesFlag)
+	if err != nil {
+		return err
+	}
+	for _, fuelType := range fuelTypes {
+		err := job.FetchAndStoreOKPrices(fuelType)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v: fetched and stored\n", fuelType.String())
+	}
+	return nil
+}
+
+func runProcessCommand(job *FetchOkDataJob, args []string) error {
+	flags := flag.NewFlagSet("process", flag.ExitOnError)
+	typesFlag := flags.String("types", "all", "comma separated fuel types: unleaded95, octane100, diesel or all")
+	dryRun := flags.Bool("dry-run", false, "print the changes instead of writing them")
+	flags.Parse(args)
+	fuelTypes, err := parseFuelTypes(*typesFlag)
+	if err != nil {
+		return err
+	}
+	options := ProcessOptions{DryRun: *dryRun}
+	return processFuelTypes(job, fuelTypes, options, func(fuelType FuelType) (*ProcessResult, error) {
+		jsonBytes, err := job.fetchOkJsonFromS3(fuelType)
+		if err != nil {
+			return nil, err
+		}
+		return job.ProcessOkJson(fuelType, jsonBytes, options)
+	})
+}
+
+func runReplayCommand(job *FetchOkDataJob, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	typesFlag := flags.String("types", "all", "comma separated fuel types: unleaded95, octane100, diesel or all")
+	snapshotFlag := flags.String("snapshot", "", "date of the snapshot to replay, formatted as 2006-01-02 (required)")
+	fromFlag := flags.String("from", "", "only process prices from this date, formatted as 2006-01-02")
+	toFlag := flags.String("to", "", "only process prices until this date, formatted as 2006-01-02")
+	dryRun := flags.Bool("dry-run", false, "print the changes instead of writing them")
+	flags.Parse(args)
+	fuelTypes, err := parseFuelTypes(*typesFlag)
+	if err != nil {
+		return err
+	}
+	if *snapshotFlag == "" {
+		return fmt.Errorf("replay: -snapshot is required")
+	}
+	snapshotDate, err := time.Parse("2006-01-02", *snapshotFlag)
+	if err != nil {
+		return fmt.Errorf("replay: invalid -snapshot: %w", err)
+	}
+	options, err := parseProcessOptions(*fromFlag, *toFlag, *dryRun)
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	return processFuelTypes(job, fuelTypes, options, func(fuelType FuelType) (*ProcessResult, error) {
+		return job.ReplayOkPrices(fuelType, snapshotDate, options)
+	})
+}
+
+func runBackfillCommand(job *FetchOkDataJob, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	typesFlag := flags.String("types", "all", "comma separated fuel types: unleaded95, octane100, diesel or all")
+	fromFlag := flags.String("from", "", "only process prices from this date, formatted as 2006-01-02")
+	toFlag := flags.String("to", "", "only process prices until this date, formatted as 2006-01-02")
+	dryRun := flags.Bool("dry-run", false, "print the changes instead of writing them")
+	flags.Parse(args)
+	fuelTypes, err := parseFuelTypes(*typesFlag)
+	if err != nil {
+		return err
+	}
+	options, err := parseProcessOptions(*fromFlag, *toFlag, *dryRun)
+	if err != nil {
+		return fmt.Errorf("backfill: %w", err)
+	}
+	return processFuelTypes(job, fuelTypes, options, func(fuelType FuelType) (*ProcessResult, error) {
+		return job.BackfillOkPrices(fuelType, options)
+	})
+}
+
+func processFuelTypes(job *FetchOkDataJob, fuelTypes []FuelType, options ProcessOptions, process func(FuelType) (*ProcessResult, error)) error {
+	for _, fuelType := range fuelTypes {
+		var currentPrices []Price
+		if options.DryRun {
+			// Must be read before processing, to compare against the table as it was
+			prices, err := job.appContext.PriceRepository.GetPrices(fuelType)
+			if err != nil {
+				return fmt.Errorf("failed to get current prices for %v: %w", fuelType.String(), err)
+			}
+			currentPrices = prices
+		}
+		result, err := process(fuelType)
+		if err != nil {
+			return fmt.Errorf("%v: %w", fuelType.String(), err)
+		}
+		if options.DryRun {
+			printPriceDiff(os.Stdout, result, currentPrices)
+		} else {
+			fmt.Printf("%v: stored %v prices, quarantined %v\n", fuelType.String(), result.Stored, result.Quarantined())
+		}
+		for _, anomaly := range result.Anomalies {
+			fmt.Printf("%v: anomaly: %v\n", fuelType.String(), anomaly.String())
+		}
+	}
+	return nil
+}
+
+func printPriceDiff(w io.Writer, result *ProcessResult, currentPrices []Price) {
+	currentPricesByTime := make(map[int64]Price)
+	for _, price := range currentPrices {
+		currentPricesByTime[price.Date.Unix()] = price
+	}
+	prices := make([]Price, len(result.Prices))
+	copy(prices, result.Prices)
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].Date.Before(prices[j].Date)
+	})
+	fmt.Fprintf(w, "%v: %v prices would be written, %v quarantined\n", result.FuelType.String(), len(prices), result.Quarantined())
+	for _, price := range prices {
+		date := price.Date.Format("2006-01-02")
+		if current, ok := currentPricesByTime[price.Date.Unix()]; ok {
+			fmt.Fprintf(w, "~ %v %v: %.2f -> %.2f\n", result.FuelType.String(), date, current.Price, price.Price)
+		} else {
+			fmt.Fprintf(w, "+ %v %v: %.2f\n", result.FuelType.String(), date, price.Price)
+		}
+	}
+}
+
+func parseFuelTypes(typesStr string) ([]FuelType, error) {
+	if typesStr == "" || strings.ToLower(typesStr) == "all" {
+		return allFuelTypes, nil
+	}
+	fuelTypes := make([]FuelType, 0)
+	for _, typeStr := range strings.Split(typesStr, ",") {
+		typeStr = strings.TrimSpace(typeStr)
+		found := false
+		for _, fuelType := range allFuelTypes {
+			if strings.EqualFold(typeStr, fuelType.String()) {
+				fuelTypes = append(fuelTypes, fuelType)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown fuel type %q", typeStr)
+		}
+	}
+	return fuelTypes, nil
+}
+
+func parseProcessOptions(fromStr string, toStr string, dryRun bool) (ProcessOptions, error) {
+	options := ProcessOptions{DryRun: dryRun}
+	if fromStr != "" {
+		from, err := time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			return options, fmt.Errorf("invalid -from: %w", err)
+		}
+		options.From = from
+	}
+	if toStr != "" {
+		to, err := time.Parse("2006-01-02", toStr)
+		if err != nil {
+			return options, fmt.Errorf("invalid -to: %w", err)
+		}
+		options.To = to
+	}
+	if !options.From.IsZero() && !options.To.IsZero() && options.To.Before(options.From) {
+		return options, fmt.Errorf("-to must not be before -from")
+	}
+	return options, nil
+}
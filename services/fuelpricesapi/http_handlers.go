@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -33,6 +35,13 @@ func (h *HttpHandler) RunJob(jobKey string) gin.HandlerFunc {
 
 func (h *HttpHandler) GetPrices(c *gin.Context) {
 	arguments := parseArguments(c)
+	converter, err := h.getPriceConverter(c.Query("unit"), c.Query("currency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	prices, err := h.appContext.PriceRepository.GetPricesForDate(arguments.fuelType, arguments.date)
 	if err != nil {
 		log.Printf("failed to get prices: %v", err)
@@ -41,6 +50,7 @@ func (h *HttpHandler) GetPrices(c *gin.Context) {
 		})
 		return
 	}
+	prices = converter.ConvertDayPrices(prices)
 	c.JSON(http.StatusOK, gin.H{
 		"message": arguments.language.GetText(prices, arguments.fuelType),
 		"prices":  prices,
@@ -51,6 +61,13 @@ func (h *HttpHandler) GetAllPrices(c *gin.Context) {
 	from := parseDate(c.Query("from"), time.Now().AddDate(-1, 0, 0).Truncate(24*time.Hour))
 	to := parseDate(c.Query("to"), time.Now().Truncate(24*time.Hour))
 	fuelType := parseFuelType(c.Query("type"))
+	converter, err := h.getPriceConverter(c.Query("unit"), c.Query("currency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
 	prices, err := h.appContext.PriceRepository.GetPricesBetweenDates(fuelType, from, to)
 	if err != nil {
@@ -60,7 +77,70 @@ func (h *HttpHandler) GetAllPrices(c *gin.Context) {
 		})
 		return
 	}
-	c.JSON(http.StatusOK, prices)
+	c.JSON(http.StatusOK, converter.ConvertAll(prices))
+}
+
+func (h *HttpHandler) GetTripCost(c *gin.Context) {
+	arguments := parseArguments(c)
+	liters, err := tripLiters(parseFloat(c.Query("liters")), parseFloat(c.Query("distance")), parseFloat(c.Query("consumption")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": arguments.language.GetTripArgumentsErrorText(),
+		})
+		return
+	}
+	// The amount is always in liters, so only the currency is converted
+	converter, err := h.getPriceConverter(string(PriceUnitLiter), c.Query("currency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	prices, err := h.appContext.PriceRepository.GetPricesForDate(arguments.fuelType, arguments.date)
+	if err != nil && !errors.Is(err, ErrNoPricesFound) {
+		log.Printf("failed to get prices: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": arguments.language.GetErrorText(),
+		})
+		return
+	}
+	if prices != nil {
+		prices = converter.ConvertDayPrices(prices)
+	}
+	tripCost, err := NewTripCost(liters, prices)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "no prices for date",
+			"message": arguments.language.GetErrorText(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  arguments.language.GetTripCostText(tripCost, arguments.fuelType),
+		"tripCost": tripCost,
+	})
+}
+
+// getPriceConverter returns a converter for the requested unit and currency
+func (h *HttpHandler) getPriceConverter(unitStr string, currencyStr string) (*PriceConverter, error) {
+	unit, err := parsePriceUnit(unitStr)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := parseCurrency(currencyStr)
+	if err != nil {
+		return nil, err
+	}
+	rates := []ExchangeRate{}
+	if currency != CurrencyDKK {
+		rates, err = h.appContext.PriceRepository.GetExchangeRates(currency)
+		if err != nil {
+			log.Printf("failed to get exchange rates for %v: %v", currency, err)
+			return nil, fmt.Errorf("could not get exchange rates for %v", currency)
+		}
+	}
+	return NewPriceConverter(unit, currency, rates)
 }
 
 type getPricesArguments struct {
@@ -120,3 +200,11 @@ func parseNoCache(noCacheStr string) bool {
 	}
 	return boolVal
 }
+
+func parseFloat(floatStr string) float64 {
+	floatVal, err := strconv.ParseFloat(strings.Replace(floatStr, ",", ".", 1), 64)
+	if err != nil {
+		return 0
+	}
+	return floatVal
+}
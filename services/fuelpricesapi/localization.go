@@ -32,16 +32,105 @@ func (l Language) GetText(prices *DayPrices, fuelType FuelType) string {
 	}
 }
 
+func (l Language) GetTripArgumentsErrorText() string {
+	switch l {
+	case LangDa:
+		return "Angiv enten liter, eller distance i km og forbrug i km/l"
+	default:
+		return "Specify either liters, or distance in km and consumption in km/l"
+	}
+}
+
+func (l Language) GetTripCostText(cost *TripCost, fuelType FuelType) string {
+	switch l {
+	case LangDa:
+		return getTripCostTextDanish(cost, fuelType)
+	default:
+		return getTripCostTextEnglish(cost, fuelType)
+	}
+}
+
+func getTripCostTextEnglish(cost *TripCost, fuelType FuelType) string {
+	lang := LangEn
+	currency, _ := lang.currencyStrings(cost.Currency)
+	text := fmt.Sprintf("Filling up %.1f liters of %v costs %.2f %v today.", cost.Liters, lang.fuelTypeString(fuelType), cost.Today, currency)
+	if cost.Yesterday != nil {
+		text = fmt.Sprintf("%v Yesterday it cost %.2f %v.", text, *cost.Yesterday, currency)
+	}
+	if cost.Tomorrow != nil && cost.SavingsByWaiting != nil {
+		text = fmt.Sprintf("%v Tomorrow it will cost %.2f %v.", text, *cost.Tomorrow, currency)
+		if *cost.SavingsByWaiting > 0 {
+			text = fmt.Sprintf("%v You save %.2f %v by waiting.", text, *cost.SavingsByWaiting, currency)
+		} else if *cost.SavingsByWaiting < 0 {
+			text = fmt.Sprintf("%v Waiting costs %.2f %v more.", text, -*cost.SavingsByWaiting, currency)
+		}
+	}
+	return text
+}
+
+func getTripCostTextDanish(cost *TripCost, fuelType FuelType) string {
+	lang := LangDa
+	currency, subunit := lang.currencyStrings(cost.Currency)
+	amountText := func(amount float64) (string, error) {
+		kroner, orer, err := amountToKronerAndOrer(amount)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v %v og %v %v", kroner, currency, orer, subunit), nil
+	}
+	todayText, err := amountText(cost.Today)
+	if err != nil {
+		log.Printf("failed to convert today cost to kroner and orer: %v", err)
+		return lang.GetErrorText()
+	}
+	text := fmt.Sprintf("Det koster %v at tanke %.1f liter %v i dag.", todayText, cost.Liters, lang.fuelTypeString(fuelType))
+	if cost.Yesterday != nil {
+		yesterdayText, err := amountText(*cost.Yesterday)
+		if err != nil {
+			log.Printf("failed to convert yesterday cost to kroner and orer: %v", err)
+			return lang.GetErrorText()
+		}
+		text = fmt.Sprintf("%v I går kostede det %v.", text, yesterdayText)
+	}
+	if cost.Tomorrow != nil && cost.SavingsByWaiting != nil {
+		tomorrowText, err := amountText(*cost.Tomorrow)
+		if err != nil {
+			log.Printf("failed to convert tomorrow cost to kroner and orer: %v", err)
+			return lang.GetErrorText()
+		}
+		text = fmt.Sprintf("%v I morgen vil det koste %v.", text, tomorrowText)
+		if *cost.SavingsByWaiting != 0 {
+			savings := *cost.SavingsByWaiting
+			if savings < 0 {
+				savings = -savings
+			}
+			savingsText, err := amountText(savings)
+			if err != nil {
+				log.Printf("failed to convert savings to kroner and orer: %v", err)
+				return lang.GetErrorText()
+			}
+			if *cost.SavingsByWaiting > 0 {
+				text = fmt.Sprintf("%v Du sparer %v ved at vente.", text, savingsText)
+			} else {
+				text = fmt.Sprintf("%v Det koster %v mere at vente.", text, savingsText)
+			}
+		}
+	}
+	return text
+}
+
 func getTextEnglish(prices *DayPrices, fuelType FuelType) string {
 	lang := LangEn
-	text := fmt.Sprintf("Today, the price of %v is %.2f kroner.", lang.fuelTypeString(fuelType), prices.Today.Price)
+	currency, _ := lang.currencyStrings(prices.Today.Currency)
+	unit := lang.unitString(prices.Today.Unit)
+	text := fmt.Sprintf("Today, the price of %v is %.2f %v%v.", lang.fuelTypeString(fuelType), prices.Today.Price, currency, unit)
 	if prices.Yesterday != nil && prices.Yesterday.Price > 0 {
 		diffText := lang.getDiffText(prices.Today, prices.Yesterday)
-		text = fmt.Sprintf("%v Yesterday the price was %v: %.2f kroner.", text, diffText, prices.Yesterday.Price)
+		text = fmt.Sprintf("%v Yesterday the price was %v: %.2f %v%v.", text, diffText, prices.Yesterday.Price, currency, unit)
 	}
 	if prices.Tomorrow != nil && prices.Tomorrow.Price > 0 {
 		diffText := lang.getDiffText(prices.Today, prices.Tomorrow)
-		text = fmt.Sprintf("%v Tomorrow the price will be %v: %.2f kroner.", text, diffText, prices.Tomorrow.Price)
+		text = fmt.Sprintf("%v Tomorrow the price will be %v: %.2f %v%v.", text, diffText, prices.Tomorrow.Price, currency, unit)
 	}
 	return text
 }
@@ -54,7 +143,9 @@ func getTextDanish(prices *DayPrices, fuelType FuelType) string {
 		return lang.GetErrorText()
 	}
 
-	text := fmt.Sprintf("%v koster %v kroner og %v ører i dag.", lang.fuelTypeString(fuelType), kroner, orer)
+	currency, subunit := lang.currencyStrings(prices.Today.Currency)
+	unit := lang.unitString(prices.Today.Unit)
+	text := fmt.Sprintf("%v koster %v %v og %v %v%v i dag.", lang.fuelTypeString(fuelType), kroner, currency, orer, subunit, unit)
 	if prices.Yesterday != nil && prices.Yesterday.Price > 0 {
 		kroner, orer, err = priceToKronerAndOrer(prices.Yesterday)
 		if err != nil {
@@ -62,7 +153,7 @@ func getTextDanish(prices *DayPrices, fuelType FuelType) string {
 			return lang.GetErrorText()
 		}
 		diffText := lang.getDiffText(prices.Today, prices.Yesterday)
-		text = fmt.Sprintf("%v I går var prisen %v: %v kroner og %v ører.", text, diffText, kroner, orer)
+		text = fmt.Sprintf("%v I går var prisen %v: %v %v og %v %v%v.", text, diffText, kroner, currency, orer, subunit, unit)
 	}
 	if prices.Tomorrow != nil && prices.Tomorrow.Price > 0 {
 		kroner, orer, err = priceToKronerAndOrer(prices.Tomorrow)
@@ -71,7 +162,7 @@ func getTextDanish(prices *DayPrices, fuelType FuelType) string {
 			return lang.GetErrorText()
 		}
 		diffText := lang.getDiffText(prices.Today, prices.Tomorrow)
-		text = fmt.Sprintf("%v I morgen vil prisen være %v: %v kroner og %v ører.", text, diffText, kroner, orer)
+		text = fmt.Sprintf("%v I morgen vil prisen være %v: %v %v og %v %v%v.", text, diffText, kroner, currency, orer, subunit, unit)
 	}
 	return text
 }
@@ -98,7 +189,11 @@ func (l Language) getDiffText(today *Price, otherDay *Price) string {
 }
 
 func priceToKronerAndOrer(price *Price) (kroner string, orer string, err error) {
-	parts := strings.Split(fmt.Sprintf("%f", price.Price), ".")
+	return amountToKronerAndOrer(float64(price.Price))
+}
+
+func amountToKronerAndOrer(amount float64) (kroner string, orer string, err error) {
+	parts := strings.Split(fmt.Sprintf("%f", amount), ".")
 	if len(parts) != 2 {
 		return "0", "0", fmt.Errorf("failed to parse price")
 	}
@@ -133,3 +228,47 @@ func (l Language) fuelTypeString(fuelType FuelType) string {
 		}
 	}
 }
+
+// currencyStrings returns the names of the currency and its subunit
+func (l Language) currencyStrings(currency Currency) (string, string) {
+	switch l {
+	case LangDa:
+		switch currency {
+		case CurrencyEUR:
+			return "euro", "cent"
+		default:
+			return "kroner", "ører"
+		}
+	default:
+		switch currency {
+		case CurrencyEUR:
+			return "euros", "cents"
+		default:
+			return "kroner", "øre"
+		}
+	}
+}
+
+// unitString returns the text to put after a price. Prices per liter have none, as that is what is expected.
+func (l Language) unitString(unit PriceUnit) string {
+	switch l {
+	case LangDa:
+		switch unit {
+		case PriceUnitGallon:
+			return " pr. gallon"
+		case PriceUnit1000Liter:
+			return " pr. 1000 liter"
+		default:
+			return ""
+		}
+	default:
+		switch unit {
+		case PriceUnitGallon:
+			return " per gallon"
+		case PriceUnit1000Liter:
+			return " per 1000 liters"
+		default:
+			return ""
+		}
+	}
+}
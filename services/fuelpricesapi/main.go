@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/bjarke-xyz/go-monorepo/libs/common/db"
 	"github.com/gin-contrib/cors"
@@ -25,6 +26,14 @@ func main() {
 
 	appContext := NewAppContext(config)
 
+	isCommand, err := runCli(appContext, os.Args[1:])
+	if isCommand {
+		if err != nil {
+			log.Fatalf("command failed: %v", err)
+		}
+		return
+	}
+
 	defer appContext.JobManager.Stop()
 	appContext.JobManager.Cron("*/25 10-16 * * *", JobIdentifierOkFETCH, func() error {
 		job := NewFetchOkDataJob(appContext)
@@ -54,6 +63,7 @@ func main() {
 	})
 	r.GET("/prices", httpHandler.GetPrices)
 	r.GET("/prices/all", httpHandler.GetAllPrices)
+	r.GET("/trip-cost", httpHandler.GetTripCost)
 	r.POST("/job", httpHandler.RunJob(config.JobKey))
 	r.Run()
 }
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS fuelprices_review;
\ No newline at end of file
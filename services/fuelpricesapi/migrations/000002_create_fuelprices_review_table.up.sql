@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS fuelprices_review(
+    fueltype int not null,
+    ts TIMESTAMPTZ not null,
+    price float NOT NULL,
+    kind text NOT NULL,
+    reason text NOT NULL,
+    detected_at TIMESTAMPTZ NOT NULL,
+    approved boolean NOT NULL DEFAULT false,
+    PRIMARY KEY(fueltype, ts)
+);
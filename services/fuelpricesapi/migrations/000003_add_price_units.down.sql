@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS exchange_rates;
+ALTER TABLE fuelprices DROP COLUMN IF EXISTS currency;
+ALTER TABLE fuelprices DROP COLUMN IF EXISTS unit;
\ No newline at end of file
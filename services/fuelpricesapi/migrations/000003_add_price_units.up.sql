@@ -0,0 +1,12 @@
+ALTER TABLE fuelprices ADD COLUMN IF NOT EXISTS unit text NOT NULL DEFAULT 'liter';
+ALTER TABLE fuelprices ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT 'DKK';
+
+-- rate is the price of one unit of the currency in DKK
+CREATE TABLE IF NOT EXISTS exchange_rates(
+    currency text not null,
+    ts TIMESTAMPTZ not null,
+    rate float NOT NULL,
+    PRIMARY KEY(currency, ts)
+);
+-- DKK is pegged to EUR at the central rate 7.46038
+INSERT INTO exchange_rates (currency, ts, rate) VALUES ('EUR', '1999-01-01', 7.46038) ON CONFLICT DO NOTHING;
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OkBaseUrl          = "https://www.ok.dk"
+	okPriceHistoryPath = "/privat/produkter/ok-kort/prisudvikling/getProduktHistorik"
+	okClientUserAgent  = "fuelprices-api (+https://fuelprices-api.bjarke.xyz)"
+	okClientTimeout    = 30 * time.Second
+	okClientMaxRetries = 3
+	okClientRetryDelay = 5 * time.Second
+	// The full price history is a few hundred kilobytes, anything much larger is not a price history
+	okClientMaxBodySize = 10 * 1024 * 1024
+)
+
+var ErrOkInvalidResponse = errors.New("invalid response from ok.dk")
+
+type OkClient struct {
+	httpClient *http.Client
+	url        string
+	userAgent  string
+	maxRetries int
+	retryDelay time.Duration
+}
+
+// NewOkClient creates a client of the ok.dk api at baseUrl, which is OkBaseUrl outside of tests
+func NewOkClient(baseUrl string) *OkClient {
+	return &OkClient{
+		httpClient: &http.Client{
+			Timeout: okClientTimeout,
+		},
+		url:        strings.TrimSuffix(baseUrl, "/") + okPriceHistoryPath,
+		userAgent:  okClientUserAgent,
+		maxRetries: okClientMaxRetries,
+		retryDelay: okClientRetryDelay,
+	}
+}
+
+// retryableError is returned for failures where trying again later might succeed
+type retryableError struct {
+	err error
+}
+
+func (r *retryableError) Error() string {
+	return r.err.Error()
+}
+
+func (r *retryableError) Unwrap() error {
+	return r.err
+}
+
+// GetPriceHistory fetches the price history of the fuel type. The returned json has been validated,
+// so it is safe to store.
+func (o *OkClient) GetPriceHistory(fuelType FuelType) ([]byte, error) {
+	requestMap := map[string]string{
+		"varenr":    strconv.Itoa(fuelType.FuelTypeToOkItemNumber()),
+		"pumpepris": "true",
+	}
+	requestJson, err := json.Marshal(requestMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= o.maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := o.retryDelay * time.Duration(1<<(attempt-1))
+			log.Printf("ok client: attempt %v for %v failed, retrying in %v: %v", attempt, fuelType.String(), delay, lastErr)
+			time.Sleep(delay)
+		}
+		body, err := o.post(requestJson)
+		if err == nil {
+			err = validateOkPriceHistory(body, fuelType)
+			if err != nil {
+				return nil, err
+			}
+			return body, nil
+		}
+		lastErr = err
+		var retryable *retryableError
+		if !errors.As(err, &retryable) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("giving up after %v attempts: %w", o.maxRetries+1, lastErr)
+}
+
+func (o *OkClient) post(requestJson []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(requestJson))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", o.userAgent)
+
+	response, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, &retryableError{fmt.Errorf("error getting ok prices: %w", err)}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%w: status code %v", ErrOkInvalidResponse, response.StatusCode)
+		if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+			return nil, &retryableError{err}
+		}
+		return nil, err
+	}
+	mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && mediaType != "text/json") {
+		return nil, fmt.Errorf("%w: unexpected content type %q", ErrOkInvalidResponse, response.Header.Get("Content-Type"))
+	}
+
+	bodyBytes, err := io.ReadAll(io.LimitReader(response.Body, okClientMaxBodySize+1))
+	if err != nil {
+		return nil, &retryableError{fmt.Errorf("error reading response body: %w", err)}
+	}
+	if len(bodyBytes) > okClientMaxBodySize {
+		return nil, fmt.Errorf("%w: body is larger than %v bytes", ErrOkInvalidResponse, okClientMaxBodySize)
+	}
+	return bodyBytes, nil
+}
+
+// validateOkPriceHistory checks that the json matches the schema of okPriceHistoryResponse:
+// all fields must be present with the right types, and every item must be for the requested fuel type.
+func validateOkPriceHistory(jsonBytes []byte, fuelType FuelType) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(jsonBytes, &fields)
+	if err != nil {
+		return fmt.Errorf("%w: body is not a json object: %v", ErrOkInvalidResponse, err)
+	}
+	for _, field := range []string{"visPriserFor1000Liter", "historik"} {
+		value, ok := fields[field]
+		if !ok || string(value) == "null" {
+			return fmt.Errorf("%w: missing field %q", ErrOkInvalidResponse, field)
+		}
+	}
+
+	var itemFields []map[string]json.RawMessage
+	err = json.Unmarshal(fields["historik"], &itemFields)
+	if err != nil {
+		return fmt.Errorf("%w: historik is not a list of objects: %v", ErrOkInvalidResponse, err)
+	}
+	if len(itemFields) == 0 {
+		return fmt.Errorf("%w: historik is empty", ErrOkInvalidResponse)
+	}
+	for i, item := range itemFields {
+		for _, field := range []string{"dato", "varenr", "pris"} {
+			value, ok := item[field]
+			if !ok || string(value) == "null" {
+				return fmt.Errorf("%w: historik[%v] is missing field %q", ErrOkInvalidResponse, i, field)
+			}
+		}
+	}
+
+	resp := okPriceHistoryResponse{}
+	err = json.Unmarshal(jsonBytes, &resp)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOkInvalidResponse, err)
+	}
+	for i, item := range resp.History {
+		if !item.Date.IsSet() {
+			return fmt.Errorf("%w: historik[%v] has no date", ErrOkInvalidResponse, i)
+		}
+		if item.ItemNo != fuelType.FuelTypeToOkItemNumber() {
+			return fmt.Errorf("%w: historik[%v] is for item %v, expected %v", ErrOkInvalidResponse, i, item.ItemNo, fuelType.FuelTypeToOkItemNumber())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const validOkPriceHistory = `{"visPriserFor1000Liter":false,"historik":[{"dato":"2022-10-20T00:00:00","varenr":536,"pris":15.49},{"dato":"2022-10-21T00:00:00","varenr":536,"pris":15.29}]}`
+
+// newTestOkClient returns a client of a fake ok.dk server, which answers each request with the next of the handlers,
+// and a counter of the requests made
+func newTestOkClient(t *testing.T, handlers ...http.HandlerFunc) (*OkClient, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if r.URL.Path != okPriceHistoryPath {
+			t.Errorf("request to %v, expected %v", r.URL.Path, okPriceHistoryPath)
+		}
+		if r.Method != http.MethodPost {
+			t.Errorf("request with method %v, expected POST", r.Method)
+		}
+		index := int(n) - 1
+		if index >= len(handlers) {
+			index = len(handlers) - 1
+		}
+		handlers[index](w, r)
+	}))
+	t.Cleanup(server.Close)
+	client := NewOkClient(server.URL)
+	client.retryDelay = time.Millisecond
+	return client, &requests
+}
+
+func respond(statusCode int, contentType string, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(statusCode)
+		w.Write([]byte(body))
+	}
+}
+
+func TestOkClientRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+	}{
+		{"internal server error", http.StatusInternalServerError},
+		{"bad gateway", http.StatusBadGateway},
+		{"too many requests", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := newTestOkClient(t,
+				respond(tt.statusCode, "text/plain", "try again"),
+				respond(tt.statusCode, "text/plain", "try again"),
+				respond(http.StatusOK, "application/json; charset=utf-8", validOkPriceHistory),
+			)
+			body, err := client.GetPriceHistory(FuelTypeUnleaded95)
+			if err != nil {
+				t.Fatalf("GetPriceHistory() error = %v", err)
+			}
+			if string(body) != validOkPriceHistory {
+				t.Errorf("GetPriceHistory() = %s, expected %s", body, validOkPriceHistory)
+			}
+			if got := atomic.LoadInt32(requests); got != 3 {
+				t.Errorf("made %v requests, expected 3", got)
+			}
+		})
+	}
+}
+
+func TestOkClientGivesUp(t *testing.T) {
+	client, requests := newTestOkClient(t, respond(http.StatusServiceUnavailable, "text/plain", "down"))
+	_, err := client.GetPriceHistory(FuelTypeUnleaded95)
+	if !errors.Is(err, ErrOkInvalidResponse) {
+		t.Fatalf("GetPriceHistory() error = %v, expected %v", err, ErrOkInvalidResponse)
+	}
+	if got, expected := atomic.LoadInt32(requests), int32(okClientMaxRetries+1); got != expected {
+		t.Errorf("made %v requests, expected %v", got, expected)
+	}
+}
+
+func TestOkClientInvalidResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"bad request", respond(http.StatusBadRequest, "application/json", `{"error":"bad request"}`)},
+		{"not found", respond(http.StatusNotFound, "text/html", "<html>not found</html>")},
+		{"html instead of json", respond(http.StatusOK, "text/html; charset=utf-8", "<html>maintenance</html>")},
+		{"missing content type", respond(http.StatusOK, "", validOkPriceHistory)},
+		{"oversize body", respond(http.StatusOK, "application/json", `{"historik":"`+strings.Repeat("a", okClientMaxBodySize)+`"}`)},
+		{"invalid schema", respond(http.StatusOK, "application/json", `{"historik":[]}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := newTestOkClient(t, tt.handler)
+			body, err := client.GetPriceHistory(FuelTypeUnleaded95)
+			if !errors.Is(err, ErrOkInvalidResponse) {
+				t.Fatalf("GetPriceHistory() error = %v, expected %v", err, ErrOkInvalidResponse)
+			}
+			if body != nil {
+				t.Errorf("GetPriceHistory() returned a body with the error")
+			}
+			if got := atomic.LoadInt32(requests); got != 1 {
+				t.Errorf("made %v requests, expected 1", got)
+			}
+		})
+	}
+}
+
+func TestValidateOkPriceHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		valid bool
+	}{
+		{"valid", validOkPriceHistory, true},
+		{"prices per 1000 liter", `{"visPriserFor1000Liter":true,"historik":[{"dato":"2022-10-20T00:00:00","varenr":536,"pris":15490}]}`, true},
+		{"not json", `<html></html>`, false},
+		{"not an object", `[1, 2, 3]`, false},
+		{"missing visPriserFor1000Liter", `{"historik":[{"dato":"2022-10-20T00:00:00","varenr":536,"pris":15.49}]}`, false},
+		{"missing historik", `{"visPriserFor1000Liter":false}`, false},
+		{"null historik", `{"visPriserFor1000Liter":false,"historik":null}`, false},
+		{"empty historik", `{"visPriserFor1000Liter":false,"historik":[]}`, false},
+		{"historik is not a list", `{"visPriserFor1000Liter":false,"historik":{"dato":"2022-10-20T00:00:00"}}`, false},
+		{"item without date", `{"visPriserFor1000Liter":false,"historik":[{"varenr":536,"pris":15.49}]}`, false},
+		{"item with null date", `{"visPriserFor1000Liter":false,"historik":[{"dato":null,"varenr":536,"pris":15.49}]}`, false},
+		{"item without price", `{"visPriserFor1000Liter":false,"historik":[{"dato":"2022-10-20T00:00:00","varenr":536}]}`, false},
+		{"price is a string", `{"visPriserFor1000Liter":false,"historik":[{"dato":"2022-10-20T00:00:00","varenr":536,"pris":"15.49"}]}`, false},
+		{"invalid date", `{"visPriserFor1000Liter":false,"historik":[{"dato":"20-10-2022","varenr":536,"pris":15.49}]}`, false},
+		{"other fuel type", `{"visPriserFor1000Liter":false,"historik":[{"dato":"2022-10-20T00:00:00","varenr":231,"pris":15.49}]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOkPriceHistory([]byte(tt.json), FuelTypeUnleaded95)
+			if tt.valid && err != nil {
+				t.Errorf("validateOkPriceHistory() error = %v, expected valid", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrOkInvalidResponse) {
+				t.Errorf("validateOkPriceHistory() error = %v, expected %v", err, ErrOkInvalidResponse)
+			}
+		})
+	}
+}
@@ -1,14 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -73,30 +69,85 @@ const s3Bucket = "fuelprices"
 
 var allFuelTypes = []FuelType{FuelTypeUnleaded95, FuelTypeOctane100, FuelTypeDiesel}
 
-func (f *FetchOkDataJob) ProcessOkPrices(fuelType FuelType) error {
+// ProcessOptions limits which prices are processed. Zero From/To means no limit.
+// With DryRun set nothing is written to the database.
+type ProcessOptions struct {
+	From   time.Time
+	To     time.Time
+	DryRun bool
+}
+
+func (o ProcessOptions) includes(date time.Time) bool {
+	if !o.From.IsZero() && date.Before(o.From) {
+		return false
+	}
+	if !o.To.IsZero() && date.After(o.To) {
+		return false
+	}
+	return true
+}
+
+func (f *FetchOkDataJob) ProcessOkPrices(fuelType FuelType) (*ProcessResult, error) {
 	jsonBytes, err := f.fetchOkJsonFromS3(fuelType)
 	if err != nil {
 		if errors.Is(err, ErrNoSuchKey) {
 			jsonBytes, err = f.fetchOkJsonFromSource(fuelType)
 			if err != nil {
-				return fmt.Errorf("attempted to fetch from source because it was not found in S3, but it failed: %v", err)
+				return nil, fmt.Errorf("attempted to fetch from source because it was not found in S3, but it failed: %v", err)
 			}
 		} else {
-			return fmt.Errorf("failed to fetch ok json from s3: %v", err)
+			return nil, fmt.Errorf("failed to fetch ok json from s3: %v", err)
 		}
 	}
 
-	prices, err := f.processOkJson(fuelType, jsonBytes)
+	return f.ProcessOkJson(fuelType, jsonBytes, ProcessOptions{})
+}
+
+// ReplayOkPrices processes the snapshot of the ok.dk response stored on the given date
+func (f *FetchOkDataJob) ReplayOkPrices(fuelType FuelType, snapshotDate time.Time, options ProcessOptions) (*ProcessResult, error) {
+	jsonBytes, err := f.appContext.Storage.Get(s3Bucket, fuelType.GetSnapshotStorageKey(snapshotDate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ok json snapshot for %v: %w", snapshotDate.Format("2006-01-02"), err)
+	}
+	return f.ProcessOkJson(fuelType, jsonBytes, options)
+}
+
+// BackfillOkPrices fetches the full price history from ok.dk and processes it, without storing the response
+func (f *FetchOkDataJob) BackfillOkPrices(fuelType FuelType, options ProcessOptions) (*ProcessResult, error) {
+	jsonBytes, err := f.fetchOkJsonFromSource(fuelType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ok json from source: %w", err)
+	}
+	return f.ProcessOkJson(fuelType, jsonBytes, options)
+}
+
+func (f *FetchOkDataJob) ProcessOkJson(fuelType FuelType, jsonBytes []byte, options ProcessOptions) (*ProcessResult, error) {
+	prices, anomalies, err := f.processOkJson(fuelType, jsonBytes, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process ok json: %v", err)
+	}
+	result := &ProcessResult{
+		FuelType:  fuelType,
+		Prices:    prices,
+		Stored:    len(prices),
+		Anomalies: anomalies,
+	}
+	if options.DryRun {
+		result.Stored = 0
+		return result, nil
+	}
+
+	err = f.appContext.PriceRepository.QuarantinePrices(anomalies)
 	if err != nil {
-		return fmt.Errorf("failed to process ok json: %v", err)
+		return nil, fmt.Errorf("failed to quarantine suspicious ok prices: %v", err)
 	}
 
 	err = f.storeProcessedOkPrices(fuelType, prices)
 	if err != nil {
-		return fmt.Errorf("failed to store processed ok prices: %v", err)
+		return nil, fmt.Errorf("failed to store processed ok prices: %v", err)
 	}
 
-	return nil
+	return result, nil
 }
 
 func (f *FetchOkDataJob) FetchAndStoreOKPrices(fuelType FuelType) error {
@@ -123,33 +174,56 @@ func (f *FetchOkDataJob) ExecuteFetchJob() error {
 	return nil
 }
 
+// ExecuteProcessJob processes the prices of all fuel types. Anomalies are logged, not returned as an error,
+// since quarantined prices wait for review and date gaps are in the source data.
 func (f *FetchOkDataJob) ExecuteProcessJob() error {
 	for _, fuelType := range allFuelTypes {
-		err := f.ProcessOkPrices(fuelType)
+		result, err := f.ProcessOkPrices(fuelType)
 		if err != nil {
 			return err
 		}
+		log.Printf("OK data job: %v stored %v prices, quarantined %v, found %v anomalies", fuelType.String(), result.Stored, result.Quarantined(), len(result.Anomalies))
+		for _, anomaly := range result.Anomalies {
+			log.Printf("OK data job: anomaly: %v", anomaly.String())
+		}
 	}
 	return nil
 }
 
-func (f *FetchOkDataJob) processOkJson(fuelType FuelType, jsonBytes []byte) ([]Price, error) {
+func (f *FetchOkDataJob) processOkJson(fuelType FuelType, jsonBytes []byte, options ProcessOptions) ([]Price, []PriceAnomaly, error) {
 	okPriceResp := &okPriceHistoryResponse{}
 	err := json.Unmarshal(jsonBytes, okPriceResp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal json for type %v: %w", fuelType, err)
+		return nil, nil, fmt.Errorf("failed to unmarshal json for type %v: %w", fuelType, err)
 	}
 
 	currentPrices, err := f.appContext.PriceRepository.GetPrices(fuelType)
 	if err != nil {
-		return nil, fmt.Errorf("error getting current prices: %w", err)
+		return nil, nil, fmt.Errorf("error getting current prices: %w", err)
+	}
+	reviewPrices, err := f.appContext.PriceRepository.GetReviewPrices(fuelType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting review prices: %w", err)
 	}
 	currentPricesByTime := make(map[int64]Price)
 	for _, price := range currentPrices {
 		currentPricesByTime[price.Date.Unix()] = price
 	}
-	prices := make([]Price, 0)
+	history := make([]okPriseHistoryItem, 0, len(okPriceResp.History))
 	for _, okPrice := range okPriceResp.History {
+		if options.includes(okPrice.Date.Time) {
+			history = append(history, okPrice)
+		}
+	}
+	// Prices are always stored per liter
+	okUnit := PriceUnitLiter
+	if okPriceResp.ShowPricesFor1000Liter {
+		okUnit = PriceUnit1000Liter
+		log.Printf("OK data job: ok.dk returned prices per 1000 liter for %v, converting to per liter", fuelType.String())
+	}
+	prices := make([]Price, 0)
+	for _, okPrice := range history {
+		okPrice.Price = float32(float64(okPrice.Price) / okUnit.liters())
 		currentPrice, ok := currentPricesByTime[okPrice.Date.Time.Unix()]
 		prevPrices := make([]PreviousPrice, 0)
 		// includePrice is used to check if we should update/insert this price at all
@@ -177,12 +251,22 @@ func (f *FetchOkDataJob) processOkJson(fuelType FuelType, jsonBytes []byte) ([]P
 				Date:       okPrice.Date.Time,
 				Price:      okPrice.Price,
 				PrevPrices: prevPrices,
+				Unit:       PriceUnitLiter,
+				Currency:   CurrencyDKK,
 			}
 			prices = append(prices, price)
 		}
 	}
 
-	return prices, nil
+	newPrices := prices
+	prices, anomalies := validatePrices(fuelType, prices, currentPrices, reviewPrices)
+	dates := make([]time.Time, 0, len(history))
+	for _, okPrice := range history {
+		dates = append(dates, okPrice.Date.Time)
+	}
+	anomalies = append(anomalies, findDateGaps(fuelType, dates, newPrices)...)
+
+	return prices, anomalies, nil
 }
 
 func (f *FetchOkDataJob) storeProcessedOkPrices(fuelType FuelType, prices []Price) error {
@@ -196,24 +280,10 @@ func (f *FetchOkDataJob) storeProcessedOkPrices(fuelType FuelType, prices []Pric
 }
 
 func (f *FetchOkDataJob) fetchOkJsonFromSource(fuelType FuelType) ([]byte, error) {
-	url := "https://www.ok.dk/privat/produkter/ok-kort/prisudvikling/getProduktHistorik"
-	requestMap := map[string]string{
-		"varenr":    strconv.Itoa(fuelType.FuelTypeToOkItemNumber()),
-		"pumpepris": "true",
-	}
-	requestJson, err := json.Marshal(requestMap)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %w", err)
-	}
-	response, err := http.Post(url, "application/json", bytes.NewBuffer(requestJson))
+	bodyBytes, err := f.appContext.OkClient.GetPriceHistory(fuelType)
 	if err != nil {
 		return nil, fmt.Errorf("error getting ok prices: %w", err)
 	}
-	defer response.Body.Close()
-	bodyBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
 	return bodyBytes, nil
 }
 
@@ -222,6 +292,10 @@ func (f *FetchOkDataJob) storeOkJson(okJson []byte, fuelType FuelType) error {
 	if err != nil {
 		return fmt.Errorf("failed to write to storage bucket: %w", err)
 	}
+	err = f.appContext.Storage.Put(s3Bucket, fuelType.GetSnapshotStorageKey(time.Now()), okJson)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot to storage bucket: %w", err)
+	}
 	return nil
 }
 
@@ -44,6 +44,11 @@ func (f FuelType) GetStorageKey() string {
 	return "/go/prices/" + f.String() + ".json"
 }
 
+// GetSnapshotStorageKey returns the key of the daily snapshot of the ok.dk response, used for replaying
+func (f FuelType) GetSnapshotStorageKey(date time.Time) string {
+	return "/go/prices/snapshots/" + f.String() + "/" + date.UTC().Format("2006-01-02") + ".json"
+}
+
 type PreviousPriceSlice []PreviousPrice
 
 type PreviousPrice struct {
@@ -72,6 +77,8 @@ type Price struct {
 	Date       time.Time          `db:"ts" json:"date"`
 	Price      float32            `json:"price"`
 	PrevPrices PreviousPriceSlice `db:"prev_prices" json:"prevPrices"`
+	Unit       PriceUnit          `db:"unit" json:"unit"`
+	Currency   Currency           `db:"currency" json:"currency"`
 }
 
 type PriceRepository struct {
@@ -172,10 +179,10 @@ func (p *PriceRepository) UpsertPrices(prices []Price) error {
 	// excluded contains the data of the row, where the insert failed
 	// So we can use that for the update
 	_, err = db.NamedExec(
-		"INSERT INTO fuelprices (fueltype, ts, price, prev_prices) "+
-			"VALUES (:fueltype, :ts, :price, :prev_prices) "+
+		"INSERT INTO fuelprices (fueltype, ts, price, prev_prices, unit, currency) "+
+			"VALUES (:fueltype, :ts, :price, :prev_prices, :unit, :currency) "+
 			"ON CONFLICT ON CONSTRAINT fuelprices_pkey "+
-			"DO UPDATE SET price = excluded.price, prev_prices = excluded.prev_prices", prices)
+			"DO UPDATE SET price = excluded.price, prev_prices = excluded.prev_prices, unit = excluded.unit, currency = excluded.currency", prices)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to do upserts: %w", err)
@@ -188,3 +195,63 @@ func (p *PriceRepository) UpsertPrices(prices []Price) error {
 	return nil
 
 }
+
+// GetReviewPrices returns the quarantined prices of the fuel type, both the approved and those awaiting review
+func (p *PriceRepository) GetReviewPrices(fuelType FuelType) ([]PriceAnomaly, error) {
+	db, err := db.Connect(p.config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	anomalies := []PriceAnomaly{}
+	err = db.Select(&anomalies, "SELECT fueltype, ts, price, kind, reason, detected_at, approved FROM fuelprices_review WHERE fueltype = $1", fuelType)
+	if err != nil {
+		return nil, err
+	}
+	return anomalies, nil
+}
+
+func (p *PriceRepository) QuarantinePrices(anomalies []PriceAnomaly) error {
+	quarantined := make([]PriceAnomaly, 0, len(anomalies))
+	for _, anomaly := range anomalies {
+		if anomaly.Quarantined {
+			quarantined = append(quarantined, anomaly)
+		}
+	}
+	if len(quarantined) == 0 {
+		return nil
+	}
+	db, err := db.Connect(p.config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// A row that is quarantined again with a different price needs a new review
+	_, err = db.NamedExec(
+		"INSERT INTO fuelprices_review (fueltype, ts, price, kind, reason, detected_at) "+
+			"VALUES (:fueltype, :ts, :price, :kind, :reason, :detected_at) "+
+			"ON CONFLICT ON CONSTRAINT fuelprices_review_pkey "+
+			"DO UPDATE SET price = excluded.price, kind = excluded.kind, reason = excluded.reason, detected_at = excluded.detected_at, "+
+			"approved = fuelprices_review.approved AND fuelprices_review.price = excluded.price", quarantined)
+	if err != nil {
+		return fmt.Errorf("failed to insert review prices: %w", err)
+	}
+	return nil
+}
+
+func (p *PriceRepository) GetExchangeRates(currency Currency) ([]ExchangeRate, error) {
+	db, err := db.Connect(p.config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rates := []ExchangeRate{}
+	err = db.Select(&rates, "SELECT currency, ts, rate FROM exchange_rates WHERE currency = $1 ORDER BY ts", currency)
+	if err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
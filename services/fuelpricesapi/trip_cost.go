@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+)
+
+var ErrInvalidTripArguments = errors.New("either liters, or distance and consumption, must be positive numbers")
+
+type TripCost struct {
+	Liters   float64  `json:"liters"`
+	Currency Currency `json:"currency"`
+	Today    float64  `json:"today"`
+	// Yesterday and Tomorrow are nil if there is no price for the day
+	Yesterday *float64 `json:"yesterday"`
+	Tomorrow  *float64 `json:"tomorrow"`
+	// SavingsByWaiting is how much cheaper it is to wait until tomorrow. It is negative if tomorrow is more expensive.
+	SavingsByWaiting *float64 `json:"savingsByWaiting"`
+}
+
+// tripLiters returns the liters needed, either given directly, or calculated from a distance in km and a consumption in km/l
+func tripLiters(liters float64, distance float64, consumption float64) (float64, error) {
+	if liters > 0 {
+		return liters, nil
+	}
+	if distance > 0 && consumption > 0 {
+		return distance / consumption, nil
+	}
+	return 0, ErrInvalidTripArguments
+}
+
+// NewTripCost calculates the cost of the liters with the prices, which must be per liter.
+// ErrNoPricesFound is returned if there is no price for today.
+func NewTripCost(liters float64, prices *DayPrices) (*TripCost, error) {
+	if prices == nil || prices.Today == nil {
+		return nil, ErrNoPricesFound
+	}
+	cost := func(price *Price) *float64 {
+		if price == nil || price.Price <= 0 {
+			return nil
+		}
+		c := liters * float64(price.Price)
+		return &c
+	}
+	tripCost := &TripCost{
+		Liters:    liters,
+		Currency:  prices.Today.Currency,
+		Today:     liters * float64(prices.Today.Price),
+		Yesterday: cost(prices.Yesterday),
+		Tomorrow:  cost(prices.Tomorrow),
+	}
+	if tripCost.Tomorrow != nil {
+		savings := tripCost.Today - *tripCost.Tomorrow
+		tripCost.SavingsByWaiting = &savings
+	}
+	return tripCost, nil
+}
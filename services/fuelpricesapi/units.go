@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PriceUnit string
+
+const (
+	PriceUnitLiter     PriceUnit = "liter"
+	PriceUnitGallon    PriceUnit = "gallon"
+	PriceUnit1000Liter PriceUnit = "1000liter"
+)
+
+// litersPerGallon is the number of liters in a US gallon
+const litersPerGallon = 3.785411784
+
+func (u PriceUnit) liters() float64 {
+	switch u {
+	case PriceUnitGallon:
+		return litersPerGallon
+	case PriceUnit1000Liter:
+		return 1000
+	default:
+		return 1
+	}
+}
+
+type Currency string
+
+const (
+	CurrencyDKK Currency = "DKK"
+	CurrencyEUR Currency = "EUR"
+)
+
+// ExchangeRate is the price of one unit of Currency in DKK, valid from Date
+type ExchangeRate struct {
+	Currency Currency  `db:"currency" json:"currency"`
+	Date     time.Time `db:"ts" json:"date"`
+	Rate     float64   `db:"rate" json:"rate"`
+}
+
+func parsePriceUnit(unitStr string) (PriceUnit, error) {
+	switch strings.ToLower(unitStr) {
+	case "", "liter", "l":
+		return PriceUnitLiter, nil
+	case "gallon", "gal":
+		return PriceUnitGallon, nil
+	case "1000liter", "1000l":
+		return PriceUnit1000Liter, nil
+	default:
+		return PriceUnitLiter, fmt.Errorf("unknown unit %q", unitStr)
+	}
+}
+
+func parseCurrency(currencyStr string) (Currency, error) {
+	switch strings.ToUpper(currencyStr) {
+	case "", "DKK":
+		return CurrencyDKK, nil
+	case "EUR":
+		return CurrencyEUR, nil
+	default:
+		return CurrencyDKK, fmt.Errorf("unknown currency %q", currencyStr)
+	}
+}
+
+// PriceConverter converts prices stored in DKK per liter to another unit and currency
+type PriceConverter struct {
+	unit     PriceUnit
+	currency Currency
+	// sorted by date, oldest first
+	rates []ExchangeRate
+}
+
+func NewPriceConverter(unit PriceUnit, currency Currency, rates []ExchangeRate) (*PriceConverter, error) {
+	sorted := make([]ExchangeRate, 0, len(rates))
+	for _, rate := range rates {
+		if rate.Currency == currency && rate.Rate > 0 {
+			sorted = append(sorted, rate)
+		}
+	}
+	if currency != CurrencyDKK && len(sorted) == 0 {
+		return nil, fmt.Errorf("no exchange rates found for %v", currency)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	return &PriceConverter{
+		unit:     unit,
+		currency: currency,
+		rates:    sorted,
+	}, nil
+}
+
+// rate returns the latest rate at the date. Dates before the first rate use the first rate.
+func (c *PriceConverter) rate(date time.Time) float64 {
+	if c.currency == CurrencyDKK {
+		return 1
+	}
+	rate := c.rates[0].Rate
+	for _, r := range c.rates {
+		if r.Date.After(date) {
+			break
+		}
+		rate = r.Rate
+	}
+	return rate
+}
+
+func (c *PriceConverter) convertValue(value float32, unit PriceUnit, date time.Time) float32 {
+	perLiter := float64(value) / unit.liters()
+	return float32(perLiter * c.unit.liters() / c.rate(date))
+}
+
+func (c *PriceConverter) Convert(price Price) Price {
+	converted := price
+	converted.Price = c.convertValue(price.Price, price.Unit, price.Date)
+	converted.PrevPrices = make(PreviousPriceSlice, 0, len(price.PrevPrices))
+	for _, prevPrice := range price.PrevPrices {
+		prevPrice.Price = c.convertValue(prevPrice.Price, price.Unit, price.Date)
+		converted.PrevPrices = append(converted.PrevPrices, prevPrice)
+	}
+	converted.Unit = c.unit
+	converted.Currency = c.currency
+	return converted
+}
+
+func (c *PriceConverter) ConvertAll(prices []Price) []Price {
+	converted := make([]Price, 0, len(prices))
+	for _, price := range prices {
+		converted = append(converted, c.Convert(price))
+	}
+	return converted
+}
+
+func (c *PriceConverter) ConvertDayPrices(dayPrices *DayPrices) *DayPrices {
+	convert := func(price *Price) *Price {
+		if price == nil {
+			return nil
+		}
+		converted := c.Convert(*price)
+		return &converted
+	}
+	return &DayPrices{
+		Today:     convert(dayPrices.Today),
+		Yesterday: convert(dayPrices.Yesterday),
+		Tomorrow:  convert(dayPrices.Tomorrow),
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxDayOverDayChange is the largest relative change from one day to the next
+// that is accepted without review
+const maxDayOverDayChange = 0.15
+
+type AnomalyKind string
+
+const (
+	AnomalyNonPositivePrice AnomalyKind = "non_positive_price"
+	AnomalyDayOverDayJump   AnomalyKind = "day_over_day_jump"
+	AnomalyDateGap          AnomalyKind = "date_gap"
+)
+
+type PriceAnomaly struct {
+	FuelType    FuelType    `db:"fueltype" json:"-"`
+	Date        time.Time   `db:"ts" json:"date"`
+	Price       float32     `db:"price" json:"price"`
+	Kind        AnomalyKind `db:"kind" json:"kind"`
+	Reason      string      `db:"reason" json:"reason"`
+	DetectedAt  time.Time   `db:"detected_at" json:"detectedAt"`
+	Approved    bool        `db:"approved" json:"approved"`
+	Quarantined bool        `db:"-" json:"quarantined"`
+}
+
+func (a PriceAnomaly) String() string {
+	return fmt.Sprintf("%v %v: %v", a.FuelType.String(), a.Date.Format("2006-01-02"), a.Reason)
+}
+
+type ProcessResult struct {
+	FuelType  FuelType
+	Prices    []Price
+	Stored    int
+	Anomalies []PriceAnomaly
+}
+
+func (p *ProcessResult) Quarantined() int {
+	count := 0
+	for _, anomaly := range p.Anomalies {
+		if anomaly.Quarantined {
+			count++
+		}
+	}
+	return count
+}
+
+func dayKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// knownPrices are the accepted prices by day, with the days kept sorted, so the latest price before a day can be found
+type knownPrices struct {
+	byDay map[string]float32
+	days  []string
+}
+
+func newKnownPrices(prices []Price) *knownPrices {
+	k := &knownPrices{byDay: make(map[string]float32, len(prices))}
+	for _, price := range prices {
+		k.set(dayKey(price.Date), price.Price)
+	}
+	return k
+}
+
+func (k *knownPrices) set(day string, price float32) {
+	if _, ok := k.byDay[day]; !ok {
+		i := sort.SearchStrings(k.days, day)
+		k.days = append(k.days, "")
+		copy(k.days[i+1:], k.days[i:])
+		k.days[i] = day
+	}
+	k.byDay[day] = price
+}
+
+// before returns the latest known price of a day before the day, however many days back it is
+func (k *knownPrices) before(day string) (float32, bool) {
+	i := sort.SearchStrings(k.days, day)
+	if i == 0 {
+		return 0, false
+	}
+	return k.byDay[k.days[i-1]], true
+}
+
+// validatePrices checks the prices about to be stored against each other and against the prices already stored.
+// Prices that look wrong are returned as quarantined anomalies instead of being accepted.
+// A price is compared to the latest accepted price before it, so a gap or a quarantined day does not skip the check.
+// Reviewed prices are the rows of the review table. Approved prices have been reviewed manually and are always accepted,
+// and prices awaiting review are left out, so they are not reported again on every run.
+func validatePrices(fuelType FuelType, prices []Price, currentPrices []Price, reviewed []PriceAnomaly) ([]Price, []PriceAnomaly) {
+	now := time.Now().UTC()
+	reviewedByDay := make(map[string]PriceAnomaly)
+	for _, r := range reviewed {
+		reviewedByDay[dayKey(r.Date)] = r
+	}
+	known := newKnownPrices(currentPrices)
+
+	sorted := make([]Price, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+
+	accepted := make([]Price, 0, len(sorted))
+	anomalies := make([]PriceAnomaly, 0)
+	for _, price := range sorted {
+		key := dayKey(price.Date)
+		if review, ok := reviewedByDay[key]; ok && review.Price == price.Price {
+			if review.Approved {
+				accepted = append(accepted, price)
+				known.set(key, price.Price)
+			}
+			continue
+		}
+		anomaly := PriceAnomaly{
+			FuelType:    fuelType,
+			Date:        price.Date,
+			Price:       price.Price,
+			DetectedAt:  now,
+			Quarantined: true,
+		}
+		if price.Price <= 0 {
+			anomaly.Kind = AnomalyNonPositivePrice
+			anomaly.Reason = fmt.Sprintf("price is %.2f", price.Price)
+			anomalies = append(anomalies, anomaly)
+			continue
+		}
+		prevPrice, ok := known.before(key)
+		if ok && prevPrice > 0 {
+			change := math.Abs(float64(price.Price-prevPrice)) / float64(prevPrice)
+			if change > maxDayOverDayChange {
+				anomaly.Kind = AnomalyDayOverDayJump
+				anomaly.Reason = fmt.Sprintf("price changed %.1f%% from %.2f to %.2f", change*100, prevPrice, price.Price)
+				anomalies = append(anomalies, anomaly)
+				continue
+			}
+		}
+		accepted = append(accepted, price)
+		known.set(key, price.Price)
+	}
+	return accepted, anomalies
+}
+
+// findDateGaps reports days missing in the source history right before one of the new prices.
+// Gaps before already known prices have been reported by an earlier run.
+func findDateGaps(fuelType FuelType, dates []time.Time, newPrices []Price) []PriceAnomaly {
+	anomalies := make([]PriceAnomaly, 0)
+	if len(dates) < 2 {
+		return anomalies
+	}
+	sorted := make([]time.Time, len(dates))
+	copy(sorted, dates)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Before(sorted[j])
+	})
+	newDays := make(map[string]bool)
+	for _, price := range newPrices {
+		newDays[dayKey(price.Date)] = true
+	}
+	now := time.Now().UTC()
+	for i := 1; i < len(sorted); i++ {
+		if !newDays[dayKey(sorted[i])] {
+			continue
+		}
+		expected := sorted[i-1].AddDate(0, 0, 1)
+		if dayKey(sorted[i]) == dayKey(sorted[i-1]) || dayKey(sorted[i]) == dayKey(expected) {
+			continue
+		}
+		missingDays := 0
+		for d := expected; dayKey(d) < dayKey(sorted[i]); d = d.AddDate(0, 0, 1) {
+			missingDays++
+		}
+		anomalies = append(anomalies, PriceAnomaly{
+			FuelType:   fuelType,
+			Date:       expected,
+			Kind:       AnomalyDateGap,
+			Reason:     fmt.Sprintf("%v days missing before %v", missingDays, dayKey(sorted[i])),
+			DetectedAt: now,
+		})
+	}
+	return anomalies
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func day(date string) time.Time {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func prices(pricesByDay ...interface{}) []Price {
+	result := make([]Price, 0, len(pricesByDay)/2)
+	for i := 0; i < len(pricesByDay); i += 2 {
+		result = append(result, Price{FuelType: FuelTypeUnleaded95, Date: day(pricesByDay[i].(string)), Price: float32(pricesByDay[i+1].(float64))})
+	}
+	return result
+}
+
+func priceDays(prices []Price) []string {
+	days := make([]string, 0, len(prices))
+	for _, price := range prices {
+		days = append(days, dayKey(price.Date))
+	}
+	return days
+}
+
+func TestValidatePrices(t *testing.T) {
+	tests := []struct {
+		name          string
+		prices        []Price
+		currentPrices []Price
+		reviewed      []PriceAnomaly
+		accepted      []string
+		anomalies     map[string]AnomalyKind
+	}{
+		{
+			name:      "first prices",
+			prices:    prices("2022-10-20", 15.0, "2022-10-21", 15.2),
+			accepted:  []string{"2022-10-20", "2022-10-21"},
+			anomalies: map[string]AnomalyKind{},
+		},
+		{
+			name:          "small change",
+			prices:        prices("2022-10-21", 16.0),
+			currentPrices: prices("2022-10-20", 15.0),
+			accepted:      []string{"2022-10-21"},
+			anomalies:     map[string]AnomalyKind{},
+		},
+		{
+			name:          "zero and negative prices",
+			prices:        prices("2022-10-21", 0.0, "2022-10-22", -1.0),
+			currentPrices: prices("2022-10-20", 15.0),
+			accepted:      []string{},
+			anomalies:     map[string]AnomalyKind{"2022-10-21": AnomalyNonPositivePrice, "2022-10-22": AnomalyNonPositivePrice},
+		},
+		{
+			name:          "jump from stored price",
+			prices:        prices("2022-10-21", 20.0),
+			currentPrices: prices("2022-10-20", 15.0),
+			accepted:      []string{},
+			anomalies:     map[string]AnomalyKind{"2022-10-21": AnomalyDayOverDayJump},
+		},
+		{
+			name:      "jump within new prices",
+			prices:    prices("2022-10-20", 15.0, "2022-10-21", 10.0),
+			accepted:  []string{"2022-10-20"},
+			anomalies: map[string]AnomalyKind{"2022-10-21": AnomalyDayOverDayJump},
+		},
+		{
+			name:          "level shift is quarantined on every day",
+			prices:        prices("2022-10-21", 20.0, "2022-10-22", 20.1, "2022-10-23", 20.2),
+			currentPrices: prices("2022-10-20", 15.0),
+			accepted:      []string{},
+			anomalies: map[string]AnomalyKind{
+				"2022-10-21": AnomalyDayOverDayJump,
+				"2022-10-22": AnomalyDayOverDayJump,
+				"2022-10-23": AnomalyDayOverDayJump,
+			},
+		},
+		{
+			name:          "jump after a date gap",
+			prices:        prices("2022-10-25", 20.0),
+			currentPrices: prices("2022-10-20", 15.0),
+			accepted:      []string{},
+			anomalies:     map[string]AnomalyKind{"2022-10-25": AnomalyDayOverDayJump},
+		},
+		{
+			name:          "jump after a non-positive price",
+			prices:        prices("2022-10-21", 0.0, "2022-10-22", 20.0),
+			currentPrices: prices("2022-10-20", 15.0),
+			accepted:      []string{},
+			anomalies:     map[string]AnomalyKind{"2022-10-21": AnomalyNonPositivePrice, "2022-10-22": AnomalyDayOverDayJump},
+		},
+		{
+			name:          "compared to the price before, not a later stored price",
+			prices:        prices("2022-10-21", 15.5),
+			currentPrices: prices("2022-10-20", 15.0, "2022-10-22", 30.0),
+			accepted:      []string{"2022-10-21"},
+			anomalies:     map[string]AnomalyKind{},
+		},
+		{
+			name:          "updated price is compared to the day before",
+			prices:        prices("2022-10-21", 15.3),
+			currentPrices: prices("2022-10-20", 15.0, "2022-10-21", 30.0),
+			accepted:      []string{"2022-10-21"},
+			anomalies:     map[string]AnomalyKind{},
+		},
+		{
+			name:          "approved price is accepted and becomes the new level",
+			prices:        prices("2022-10-21", 20.0, "2022-10-22", 20.1),
+			currentPrices: prices("2022-10-20", 15.0),
+			reviewed:      []PriceAnomaly{{Date: day("2022-10-21"), Price: 20.0, Approved: true}},
+			accepted:      []string{"2022-10-21", "2022-10-22"},
+			anomalies:     map[string]AnomalyKind{},
+		},
+		{
+			name:          "pending price is not reported again",
+			prices:        prices("2022-10-21", 20.0),
+			currentPrices: prices("2022-10-20", 15.0),
+			reviewed:      []PriceAnomaly{{Date: day("2022-10-21"), Price: 20.0}},
+			accepted:      []string{},
+			anomalies:     map[string]AnomalyKind{},
+		},
+		{
+			name:          "reviewed day with another price is checked again",
+			prices:        prices("2022-10-21", 25.0),
+			currentPrices: prices("2022-10-20", 15.0),
+			reviewed:      []PriceAnomaly{{Date: day("2022-10-21"), Price: 20.0, Approved: true}},
+			accepted:      []string{},
+			anomalies:     map[string]AnomalyKind{"2022-10-21": AnomalyDayOverDayJump},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accepted, anomalies := validatePrices(FuelTypeUnleaded95, tt.prices, tt.currentPrices, tt.reviewed)
+			if actual := priceDays(accepted); !reflect.DeepEqual(actual, tt.accepted) {
+				t.Errorf("accepted %v, expected %v", actual, tt.accepted)
+			}
+			actualAnomalies := make(map[string]AnomalyKind)
+			for _, anomaly := range anomalies {
+				if !anomaly.Quarantined {
+					t.Errorf("anomaly %v is not quarantined", anomaly)
+				}
+				actualAnomalies[dayKey(anomaly.Date)] = anomaly.Kind
+			}
+			if !reflect.DeepEqual(actualAnomalies, tt.anomalies) {
+				t.Errorf("anomalies %v, expected %v", actualAnomalies, tt.anomalies)
+			}
+		})
+	}
+}
+
+func TestFindDateGaps(t *testing.T) {
+	tests := []struct {
+		name      string
+		dates     []string
+		newPrices []Price
+		gaps      map[string]string
+	}{
+		{
+			name:      "no dates",
+			dates:     []string{},
+			newPrices: []Price{},
+			gaps:      map[string]string{},
+		},
+		{
+			name:      "consecutive days",
+			dates:     []string{"2022-10-20", "2022-10-21", "2022-10-22"},
+			newPrices: prices("2022-10-22", 15.0),
+			gaps:      map[string]string{},
+		},
+		{
+			name:      "gap before new price",
+			dates:     []string{"2022-10-20", "2022-10-23"},
+			newPrices: prices("2022-10-23", 15.0),
+			gaps:      map[string]string{"2022-10-21": "2 days missing before 2022-10-23"},
+		},
+		{
+			name:      "gap before known price",
+			dates:     []string{"2022-10-20", "2022-10-23", "2022-10-24"},
+			newPrices: prices("2022-10-24", 15.0),
+			gaps:      map[string]string{},
+		},
+		{
+			name:      "unsorted dates",
+			dates:     []string{"2022-10-25", "2022-10-20", "2022-10-21"},
+			newPrices: prices("2022-10-25", 15.0),
+			gaps:      map[string]string{"2022-10-22": "3 days missing before 2022-10-25"},
+		},
+		{
+			name:      "duplicate dates",
+			dates:     []string{"2022-10-20", "2022-10-20", "2022-10-21"},
+			newPrices: prices("2022-10-20", 15.0, "2022-10-21", 15.0),
+			gaps:      map[string]string{},
+		},
+		{
+			name:      "several gaps",
+			dates:     []string{"2022-10-20", "2022-10-22", "2022-10-25"},
+			newPrices: prices("2022-10-22", 15.0, "2022-10-25", 15.0),
+			gaps: map[string]string{
+				"2022-10-21": "1 days missing before 2022-10-22",
+				"2022-10-23": "2 days missing before 2022-10-25",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dates := make([]time.Time, 0, len(tt.dates))
+			for _, date := range tt.dates {
+				dates = append(dates, day(date))
+			}
+			anomalies := findDateGaps(FuelTypeUnleaded95, dates, tt.newPrices)
+			actual := make(map[string]string)
+			for _, anomaly := range anomalies {
+				if anomaly.Kind != AnomalyDateGap {
+					t.Errorf("anomaly %v has kind %v, expected %v", anomaly, anomaly.Kind, AnomalyDateGap)
+				}
+				actual[dayKey(anomaly.Date)] = anomaly.Reason
+			}
+			if !reflect.DeepEqual(actual, tt.gaps) {
+				t.Errorf("gaps %v, expected %v", actual, tt.gaps)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bjarke-xyz/rasende2/duda"
+	"github.com/bjarke-xyz/rasende2/rss"
+)
+
+const cliUsage = `Usage: rasende2 [command] [flags]
+
+Without a command the http server is started.
+
+Commands:
+  ingest          fetch the sources that are due once, or all enabled sources with -all
+  import-sources  import the sources in a json file in the format of rss.json
+  export-sources  write the sources as json in the format of rss.json
+  discover        find the feeds of the media on duda.dk, and add them as proposed sources
+  reindex         rebuild the search indexes
+  prune           archive and remove the content of items older than the retention of their source
+  restore         put archived content back on its items, which are then not pruned again
+  search          search the items, like GET /search
+  chart           count the items matching queries over time, like GET /charts
+
+Run 'rasende2 [command] -h' for the flags of a command.
+`
+
+// runCli runs the command given in args. The returned bool is false if args does not contain a command.
+func runCli(service *rss.RssService, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	command := args[0]
+	switch command {
+	case "ingest":
+		return true, runIngestCommand(service, args[1:])
+	case "import-sources":
+		return true, runImportSourcesCommand(service, args[1:])
+	case "export-sources":
+		return true, runExportSourcesCommand(service, args[1:])
+	case "reindex":
+		return true, runReindexCommand(service, args[1:])
+	case "prune":
+		return true, runPruneCommand(service, args[1:])
+	case "restore":
+		return true, runRestoreCommand(service, args[1:])
+	case "search":
+		return true, runSearchCommand(service, args[1:])
+	case "chart":
+		return true, runChartCommand(service, args[1:])
+	case "discover":
+		return true, runDiscoverCommand(service, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return true, nil
+	default:
+		return true, fmt.Errorf("unknown command %q\n%v", command, cliUsage)
+	}
+}
+
+func runIngestCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	all := flags.Bool("all", false, "fetch all enabled sources, also if they are not due or are backing off after failures")
+	flags.Parse(args)
+	if *all {
+		return service.FetchAndSaveAllItems()
+	}
+	return rss.NewIngestionJob(service).ExecuteJob()
+}
+
+func runImportSourcesCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("import-sources", flag.ExitOnError)
+	file := flags.String("file", "rss.json", "json file with the sources")
+	flags.Parse(args)
+	imported, err := service.ImportSourcesFromFile(*file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %v sources from %v, sources that already existed were left untouched\n", imported, *file)
+	return nil
+}
+
+func runExportSourcesCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("export-sources", flag.ExitOnError)
+	file := flags.String("file", "", "file the sources are written to, instead of stdout")
+	flags.Parse(args)
+	var w io.Writer = os.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return fmt.Errorf("export-sources: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	exported, err := service.ExportSources(w)
+	if err != nil {
+		return err
+	}
+	if *file != "" {
+		fmt.Printf("exported %v sources to %v\n", exported, *file)
+	}
+	return nil
+}
+
+func runReindexCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	flags.Parse(args)
+	err := service.ReindexSearch()
+	if err != nil {
+		return err
+	}
+	fmt.Println("reindexed the search indexes")
+	return nil
+}
+
+func runPruneCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	includeRestored := flags.Bool("include-restored", false, "also prune items whose content has been restored")
+	flags.Parse(args)
+	pruned, err := service.PruneContent(context.Background(), time.Now(), *includeRestored)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("archived and removed the content of %v items\n", pruned)
+	return nil
+}
+
+func runRestoreCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	key := flags.String("key", "", "key of the archive to restore")
+	site := flags.String("site", "", "restore all archives of this site")
+	list := flags.Bool("list", false, "list the archives, of -site if given, instead of restoring")
+	flags.Parse(args)
+	ctx := context.Background()
+	if *list {
+		keys, err := service.ListArchives(ctx, *site)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		return nil
+	}
+	keys := []string{*key}
+	if *key == "" {
+		if *site == "" {
+			return fmt.Errorf("restore: -key or -site is required")
+		}
+		var err error
+		keys, err = service.ListArchives(ctx, *site)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		restored, err := service.RestoreArchive(ctx, key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v: restored %v items\n", key, restored)
+	}
+	return nil
+}
+
+// stringsFlag is a flag that can be given several times
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func runSearchCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	query := flags.String("q", "", "search query, with the same syntax as GET /search (required)")
+	content := flags.Bool("content", false, "also search the content of the items")
+	limit := flags.Int("limit", 20, "number of items")
+	fromFlag := flags.String("from", "", "only items published from this date, formatted as 2006-01-02")
+	toFlag := flags.String("to", "", "only items published until this date, formatted as 2006-01-02")
+	sort := flags.String("sort", rss.SearchSortRecency, "sort by recency or relevance")
+	cursor := flags.String("cursor", "", "cursor of the next page, printed after the items")
+	jsonOutput := flags.Bool("json", false, "print the items as json")
+	var sites stringsFlag
+	flags.Var(&sites, "site", "only items from this site, can be given several times")
+	var languages stringsFlag
+	flags.Var(&languages, "lang", "only items in this language, da, sv, no or en, can be given several times")
+	flags.Parse(args)
+	if *query == "" {
+		return fmt.Errorf("search: -q is required")
+	}
+	from, to, err := rss.ParseSearchPeriod(*fromFlag, *toFlag)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	page, err := service.Search(context.Background(), rss.SearchParams{
+		Query:         *query,
+		SearchContent: *content,
+		Sites:         sites,
+		Languages:     languages,
+		From:          from,
+		To:            to,
+		Sort:          *sort,
+		Limit:         *limit,
+		Cursor:        *cursor,
+	})
+	if err != nil {
+		return err
+	}
+	location, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rss.NewSearchResult(page))
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, hit := range page.Hits {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", hit.Published.In(location).Format("2006-01-02 15:04"), hit.SiteName, hit.Title, hit.Link)
+	}
+	w.Flush()
+	fmt.Printf("%v of %v items\n", len(page.Hits), page.Total)
+	if page.NextCursor != "" {
+		fmt.Printf("next page: -cursor %v\n", page.NextCursor)
+	}
+	return nil
+}
+
+func runChartCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("chart", flag.ExitOnError)
+	fromFlag := flags.String("from", "", "start of the period, formatted as 2006-01-02")
+	toFlag := flags.String("to", "", "end of the period, formatted as 2006-01-02")
+	bucket := flags.String("bucket", rss.ChartBucketDay, "hour, day, week or month")
+	unique := flags.Bool("unique", false, "count each story once, also if it is syndicated to several sites")
+	sitesFlag := flags.Bool("sites", false, "also print the count of each site")
+	var queries stringsFlag
+	flags.Var(&queries, "q", "search query, can be given several times (default rasende)")
+	flags.Parse(args)
+	from, to, err := rss.ParseChartPeriod(*fromFlag, *toFlag)
+	if err != nil {
+		return fmt.Errorf("chart: %w", err)
+	}
+	series, err := service.GetChartSeries(context.Background(), rss.ChartParams{
+		Queries: queries,
+		From:    from,
+		To:      to,
+		Bucket:  *bucket,
+		Unique:  *unique,
+	})
+	if err != nil {
+		return err
+	}
+	if len(series) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "\t")
+	for _, s := range series {
+		fmt.Fprintf(w, "%v\t", s.Query)
+	}
+	fmt.Fprintln(w)
+	for i, label := range series[0].Labels {
+		fmt.Fprintf(w, "%v\t", label)
+		for _, s := range series {
+			fmt.Fprintf(w, "%v\t", s.Data[i])
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	if *sitesFlag {
+		for _, s := range series {
+			fmt.Printf("\n%v:\n", s.Query)
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, siteCount := range s.SiteCounts {
+				fmt.Fprintf(w, "%v\t%v\n", siteCount.SiteName, siteCount.Count)
+			}
+			w.Flush()
+		}
+	}
+	return nil
+}
+
+func runDiscoverCommand(service *rss.RssService, args []string) error {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	cacheDir := flags.String("cache", "cache", "directory the downloaded pages are cached in")
+	dryRun := flags.Bool("dry-run", false, "print the discovered feeds instead of adding them")
+	userAgent := flags.String("user-agent", duda.DefaultUserAgent, "user agent sent to the sites, and matched against their robots.txt")
+	concurrency := flags.Int("concurrency", 4, "number of sites crawled at the same time")
+	hostDelay := flags.Duration("host-delay", 2*time.Second, "minimum time between requests to the same site")
+	flags.Parse(args)
+	options := duda.ScraperOptions{
+		UserAgent:   *userAgent,
+		Concurrency: *concurrency,
+		HostDelay:   *hostDelay,
+	}
+	discovered, err := duda.DiscoverMediaFeeds(duda.NewCache(*cacheDir, duda.CacheOptions{}), options)
+	if err != nil {
+		return err
+	}
+	sources := make([]rss.RssSource, 0, len(discovered))
+	for _, feed := range discovered {
+		fmt.Printf("%v (%v)\n", feed.SiteName, feed.SiteUrl)
+		for _, feedUrl := range feed.FeedUrls {
+			fmt.Printf("  %v\n", feedUrl)
+		}
+		sources = append(sources, rss.RssSource{
+			Name:       feed.SiteName,
+			Urls:       feed.FeedUrls,
+			Categories: []string{"duda"},
+		})
+	}
+	if *dryRun {
+		return nil
+	}
+	proposed, err := service.ProposeSources(sources)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("proposed %v new sources of %v discovered, review them with GET /sources?proposed=true\n", proposed, len(discovered))
+	return nil
+}
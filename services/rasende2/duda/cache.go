@@ -1,50 +1,349 @@
 package duda
 
 import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	defaultMaxEntrySize = 10 * 1024 * 1024
+	defaultMaxSize      = 1024 * 1024 * 1024
+	defaultTTL          = 24 * time.Hour
+	// pruneTarget is the fraction of MaxSize the cache is pruned to, so it is not pruned on every put
+	pruneTarget = 0.9
+)
+
+// CacheOptions are the limits of a cache. Zero values are replaced by the defaults.
+type CacheOptions struct {
+	// MaxEntrySize is the largest body that is cached, larger bodies are returned but not cached
+	MaxEntrySize int64
+	// MaxSize is the total size of the cached entries, above which the oldest entries are removed
+	MaxSize int64
+	// DefaultTTL is how long entries are fresh, when Put is given a ttl of 0
+	DefaultTTL time.Duration
+}
+
+// CacheEntry is a cached http response
+type CacheEntry struct {
+	Key        string
+	Url        string
+	StatusCode int
+	Header     http.Header
+	FetchedAt  time.Time
+	ExpiresAt  time.Time
+	Body       []byte `json:"-"`
+	// FromCache is set when the body was returned from the cache, either without making a request or because the
+	// server returned 304 to validators the cache added itself
+	FromCache bool `json:"-"`
+	// NotModified is set when the server returned 304 to validators set on the request by the caller
+	NotModified bool `json:"-"`
+}
+
+// Fresh returns whether the entry can be used without asking the server
+func (e *CacheEntry) Fresh(now time.Time) bool {
+	return now.Before(e.ExpiresAt)
+}
+
+// Cache is a file cache of http responses. Keys are hashed, so any string can be a key, and entries are written
+// atomically, so a crashed write never leaves a partial entry.
 type Cache struct {
 	directory string
+	options   CacheOptions
+	mu        sync.Mutex
+	// size is the total size of the entries, or -1 if it has not been computed yet
+	size int64
 }
 
-func NewCache(directory string) *Cache {
-	if !strings.HasSuffix(directory, "/") {
-		directory = directory + "/"
+func NewCache(directory string, options CacheOptions) *Cache {
+	if options.MaxEntrySize <= 0 {
+		options.MaxEntrySize = defaultMaxEntrySize
+	}
+	if options.MaxSize <= 0 {
+		options.MaxSize = defaultMaxSize
+	}
+	if options.DefaultTTL <= 0 {
+		options.DefaultTTL = defaultTTL
+	}
+	err := os.MkdirAll(directory, os.ModePerm)
+	if err != nil {
+		log.Printf("error creating cache directory %v: %v", directory, err)
 	}
-	os.MkdirAll(directory, os.ModePerm)
 	return &Cache{
 		directory: directory,
+		options:   options,
+		size:      -1,
 	}
 }
 
-func (c *Cache) Get(key string) (string, bool) {
-	filepath := c.directory + key
-	bytes, err := os.ReadFile(filepath)
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *Cache) entryPath(key string) string {
+	hash := hashKey(key)
+	return filepath.Join(c.directory, "entries", hash[:2], hash)
+}
+
+func (c *Cache) valuePath(key string) string {
+	return filepath.Join(c.directory, "values", hashKey(key))
+}
+
+// Get returns the entry of the key, also if it is no longer fresh
+func (c *Cache) Get(key string) (*CacheEntry, bool) {
+	f, err := os.Open(c.entryPath(key))
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
-			log.Printf("error reading cache file: %v", err)
+			log.Printf("error opening cache entry: %v", err)
 		}
-		return "", false
+		return nil, false
 	}
-	return string(bytes), true
+	defer f.Close()
+	// an entry is the metadata as json on the first line, followed by the body
+	reader := bufio.NewReader(f)
+	metadata, err := reader.ReadBytes('\n')
+	if err != nil {
+		log.Printf("error reading cache entry metadata: %v", err)
+		return nil, false
+	}
+	entry := &CacheEntry{}
+	err = json.Unmarshal(metadata, entry)
+	if err != nil {
+		log.Printf("error unmarshaling cache entry metadata: %v", err)
+		return nil, false
+	}
+	entry.Body, err = io.ReadAll(reader)
+	if err != nil {
+		log.Printf("error reading cache entry body: %v", err)
+		return nil, false
+	}
+	return entry, true
 }
 
-func (c *Cache) Put(key string, value string) {
-	filepath := c.directory + key
-	filepathParts := strings.Split(filepath, "/")
-	filepathDir := strings.Join(filepathParts[:len(filepathParts)-1], "/")
-	os.MkdirAll(filepathDir, os.ModePerm)
-	f, err := os.Create(filepath)
+// Put stores the entry, which is fresh for ttl, or the default ttl if ttl is 0
+func (c *Cache) Put(key string, entry *CacheEntry, ttl time.Duration) error {
+	if int64(len(entry.Body)) > c.options.MaxEntrySize {
+		return fmt.Errorf("entry of %v bytes is larger than the max entry size of %v bytes", len(entry.Body), c.options.MaxEntrySize)
+	}
+	if ttl <= 0 {
+		ttl = c.options.DefaultTTL
+	}
+	if entry.FetchedAt.IsZero() {
+		entry.FetchedAt = time.Now()
+	}
+	entry.Key = key
+	entry.ExpiresAt = entry.FetchedAt.Add(ttl)
+	metadata, err := json.Marshal(entry)
 	if err != nil {
-		log.Printf("error creating cache file: %v", err)
+		return fmt.Errorf("error marshaling cache entry metadata: %w", err)
 	}
-	defer f.Close()
-	_, err = f.WriteString(value)
+	content := append(append(metadata, '\n'), entry.Body...)
+	path := c.entryPath(key)
+	previousSize := int64(0)
+	if info, err := os.Stat(path); err == nil {
+		previousSize = info.Size()
+	}
+	err = writeFileAtomic(path, content)
 	if err != nil {
-		log.Printf("error writing cache file: %v", err)
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size >= 0 {
+		c.size += int64(len(content)) - previousSize
+	}
+	if c.size < 0 || c.size > c.options.MaxSize {
+		c.prune()
+	}
+	return nil
+}
+
+// GetValue returns a value stored with PutValue. Values are not http responses, never expire and are not pruned.
+func (c *Cache) GetValue(key string) ([]byte, bool) {
+	value, err := os.ReadFile(c.valuePath(key))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("error reading cache value: %v", err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *Cache) PutValue(key string, value []byte) error {
+	return writeFileAtomic(c.valuePath(key), value)
+}
+
+// writeFileAtomic writes to a temporary file in the same directory, and renames it to path
+func writeFileAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating cache file: %w", err)
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing cache file: %w", err)
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error renaming cache file: %w", err)
+	}
+	return nil
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// prune removes the oldest entries until the cache is below its max size. c.mu must be held.
+func (c *Cache) prune() {
+	files := make([]cacheFile, 0)
+	total := int64(0)
+	filepath.WalkDir(filepath.Join(c.directory, "entries"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	c.size = total
+	if total <= c.options.MaxSize {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	target := int64(float64(c.options.MaxSize) * pruneTarget)
+	removed := 0
+	for _, file := range files {
+		if c.size <= target {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			log.Printf("error removing cache entry: %v", err)
+			continue
+		}
+		c.size -= file.size
+		removed++
+	}
+	log.Printf("pruned %v cache entries, cache is now %v bytes", removed, c.size)
+}
+
+// isCacheable returns whether responses with the status code are cached. Not found is cached, so missing pages
+// are not requested again until the entry expires.
+func isCacheable(statusCode int) bool {
+	return statusCode == http.StatusOK || statusCode == http.StatusNotFound || statusCode == http.StatusGone
+}
+
+// Fetch returns the response to the GET request from the cache, if it is fresh. Otherwise the request is made,
+// as a conditional request if a stale response with validators is cached, and the response is cached for ttl.
+// Bodies larger than maxBodySize are an error. A nil cache makes the request without caching.
+// Validators already set on the request are kept, and if the server then returns 304, the entry is NotModified and
+// has no body. A 304 to validators added by the cache returns the cached body instead, so the caller can always use it.
+func (c *Cache) Fetch(client *http.Client, req *http.Request, ttl time.Duration, maxBodySize int64) (*CacheEntry, error) {
+	url := req.URL.String()
+	var cached *CacheEntry
+	if c != nil {
+		if entry, ok := c.Get(url); ok {
+			if entry.Fresh(time.Now()) {
+				entry.FromCache = true
+				return entry, nil
+			}
+			cached = entry
+		}
+	}
+	revalidating := false
+	if cached != nil && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+			revalidating = true
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+			revalidating = true
+		}
+	}
+	// Setting Accept-Encoding disables the transparent decompression of the transport, so gzip is handled below.
+	// This also handles servers that send gzip without being asked to.
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %v: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		if !revalidating {
+			return &CacheEntry{Url: url, StatusCode: resp.StatusCode, Header: resp.Header, FetchedAt: time.Now(), NotModified: true}, nil
+		}
+		cached.FetchedAt = time.Now()
+		if err := c.Put(url, cached, ttl); err != nil {
+			log.Printf("error caching %v: %v", url, err)
+		}
+		cached.FromCache = true
+		return cached, nil
+	}
+
+	var reader io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gzipReader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip body of %v: %w", url, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+		resp.Header.Del("Content-Encoding")
+	}
+	body, err := io.ReadAll(io.LimitReader(reader, maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading body of %v: %w", url, err)
+	}
+	if int64(len(body)) > maxBodySize {
+		return nil, fmt.Errorf("body of %v is larger than %v bytes", url, maxBodySize)
+	}
+	entry := &CacheEntry{
+		Url:        url,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		FetchedAt:  time.Now(),
+		Body:       body,
+	}
+	if c != nil && isCacheable(resp.StatusCode) && int64(len(body)) <= c.options.MaxEntrySize {
+		if err := c.Put(url, entry, ttl); err != nil {
+			log.Printf("error caching %v: %v", url, err)
+		}
 	}
+	return entry, nil
 }
@@ -0,0 +1,137 @@
+package duda
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// commonFeedPaths are tried on sites that do not link to their feeds
+var commonFeedPaths = []string{"/rss", "/feed", "/rss.xml", "/feed.xml", "/atom.xml", "/index.xml", "/feeds/all.rss", "/service/rss"}
+
+// feedTypes are the types of <link rel="alternate"> that are feeds
+var feedTypes = []string{"application/rss+xml", "application/atom+xml", "application/feed+json", "application/json"}
+
+// DiscoveredFeed is a site from duda.dk and the feeds found on it
+type DiscoveredFeed struct {
+	SiteName string
+	SiteUrl  string
+	FeedUrls []string
+}
+
+// DiscoverFeeds finds the feeds of each of the links. Links without valid feeds are left out.
+func (s *Scraper) DiscoverFeeds(links []Link) []DiscoveredFeed {
+	found := make([][]string, len(links))
+	s.forEach(len(links), func(i int) {
+		feedUrls, err := s.FindFeeds(links[i])
+		if err != nil {
+			log.Printf("error finding feeds of %v: %v", links[i].Url, err)
+			return
+		}
+		found[i] = feedUrls
+	})
+	discovered := make([]DiscoveredFeed, 0)
+	for i, link := range links {
+		if len(found[i]) == 0 {
+			continue
+		}
+		log.Printf("found %v feeds on %v", len(found[i]), link.Url)
+		discovered = append(discovered, DiscoveredFeed{
+			SiteName: link.Title,
+			SiteUrl:  link.Url,
+			FeedUrls: found[i],
+		})
+	}
+	return discovered
+}
+
+// FindFeeds returns the valid feeds linked from the page with <link rel="alternate">. If there are none,
+// the common feed paths of the site are tried, and the first valid one is returned.
+func (s *Scraper) FindFeeds(link Link) ([]string, error) {
+	content, err := s.GetContent(link)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(link.Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %v: %w", link.Url, err)
+	}
+	candidates, err := alternateFeedUrls(base, content)
+	if err != nil {
+		return nil, err
+	}
+	feedUrls := s.validFeeds(candidates, false)
+	if len(feedUrls) > 0 {
+		return feedUrls, nil
+	}
+	candidates = make([]string, 0, len(commonFeedPaths))
+	for _, path := range commonFeedPaths {
+		candidates = append(candidates, base.ResolveReference(&url.URL{Path: path}).String())
+	}
+	return s.validFeeds(candidates, true), nil
+}
+
+// alternateFeedUrls returns the absolute urls of the feeds linked in the head of the page
+func alternateFeedUrls(base *url.URL, content string) ([]string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not create goquery document: %w", err)
+	}
+	urls := make([]string, 0)
+	doc.Find(`link[rel~="alternate"]`).Each(func(i int, link *goquery.Selection) {
+		linkType := strings.ToLower(strings.TrimSpace(link.AttrOr("type", "")))
+		isFeed := false
+		for _, feedType := range feedTypes {
+			if strings.HasPrefix(linkType, feedType) {
+				isFeed = true
+			}
+		}
+		href, ok := link.Attr("href")
+		if !isFeed || !ok {
+			return
+		}
+		feedUrl, err := base.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return
+		}
+		urls = append(urls, feedUrl.String())
+	})
+	return urls, nil
+}
+
+// validFeeds returns the candidates that are feeds with items, leaving out duplicates of the same feed
+func (s *Scraper) validFeeds(candidates []string, firstOnly bool) []string {
+	parser := gofeed.NewParser()
+	valid := make([]string, 0)
+	seenUrls := make(map[string]bool)
+	seenFeeds := make(map[string]bool)
+	for _, candidate := range candidates {
+		if seenUrls[candidate] {
+			continue
+		}
+		seenUrls[candidate] = true
+		content, err := s.GetContent(Link{Url: candidate})
+		if err != nil {
+			continue
+		}
+		feed, err := parser.ParseString(content)
+		if err != nil || len(feed.Items) == 0 {
+			continue
+		}
+		// the same feed is often available at several urls, e.g. /rss and /feed
+		feedKey := feed.Title + ":" + feed.Items[0].Link
+		if seenFeeds[feedKey] {
+			continue
+		}
+		seenFeeds[feedKey] = true
+		valid = append(valid, candidate)
+		if firstOnly {
+			break
+		}
+	}
+	return valid
+}
@@ -0,0 +1,232 @@
+package duda
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// robotsTTL is how long robots.txt is cached
+	robotsTTL     = 24 * time.Hour
+	maxRobotsSize = 512 * 1024
+	// maxCrawlDelay caps the Crawl-delay of robots.txt, so a single site cannot stall the crawl
+	maxCrawlDelay = 10 * time.Second
+)
+
+type robotsRule struct {
+	allow bool
+	path  string
+}
+
+// robotsRules are the rules of robots.txt that apply to the user agent of the scraper
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// allowed returns whether the path can be crawled. The longest matching rule wins, and allow wins a tie.
+func (r *robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	allowed := true
+	matchLength := -1
+	for _, rule := range r.rules {
+		if !robotsPathMatches(rule.path, path) {
+			continue
+		}
+		if len(rule.path) > matchLength || (len(rule.path) == matchLength && rule.allow) {
+			allowed = rule.allow
+			matchLength = len(rule.path)
+		}
+	}
+	return allowed
+}
+
+// robotsPathMatches matches a path of robots.txt, which can contain * wildcards and end with $
+func robotsPathMatches(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	expression := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")), `\*`, ".*")
+	if anchored {
+		expression += "$"
+	}
+	matched, err := regexp.MatchString(expression, path)
+	return err == nil && matched
+}
+
+// parseRobots returns the rules of the group matching the product token of the user agent,
+// or of the * group if no group matches
+func parseRobots(content string, userAgent string) *robotsRules {
+	product := strings.ToLower(strings.SplitN(userAgent, "/", 2)[0])
+	product = strings.TrimSpace(strings.SplitN(product, " ", 2)[0])
+
+	specific := &robotsRules{}
+	wildcard := &robotsRules{}
+	foundSpecific := false
+	// the groups the current lines belong to. Consecutive user-agent lines start a single group.
+	var current []*robotsRules
+	inAgents := false
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if key == "user-agent" {
+			if !inAgents {
+				current = nil
+				inAgents = true
+			}
+			agent := strings.ToLower(value)
+			if agent == "*" {
+				current = append(current, wildcard)
+			} else if product != "" && strings.Contains(product, agent) {
+				current = append(current, specific)
+				foundSpecific = true
+			}
+			continue
+		}
+		inAgents = false
+		for _, rules := range current {
+			switch key {
+			case "allow", "disallow":
+				// an empty disallow allows everything
+				if value != "" {
+					rules.rules = append(rules.rules, robotsRule{allow: key == "allow", path: value})
+				}
+			case "crawl-delay":
+				if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+					rules.crawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+	if foundSpecific {
+		return specific
+	}
+	return wildcard
+}
+
+// getRobots returns the robots.txt rules of the host of the url. A missing robots.txt allows everything,
+// and so does a robots.txt that cannot be fetched, except when the server returns 401 or 403.
+func (s *Scraper) getRobots(link *url.URL) (*robotsRules, error) {
+	s.robotsMu.Lock()
+	rules, ok := s.robots[link.Host]
+	s.robotsMu.Unlock()
+	if ok {
+		return rules, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, link.Scheme+"://"+link.Host+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", s.options.UserAgent)
+	entry, err := s.cache.Fetch(s.client, req, robotsTTL, maxRobotsSize)
+	switch {
+	case err != nil:
+		rules = &robotsRules{}
+	case entry.StatusCode == http.StatusUnauthorized || entry.StatusCode == http.StatusForbidden:
+		rules = &robotsRules{rules: []robotsRule{{allow: false, path: "/"}}}
+	case entry.StatusCode == http.StatusOK:
+		rules = parseRobots(string(entry.Body), s.options.UserAgent)
+	default:
+		rules = &robotsRules{}
+	}
+	s.robotsMu.Lock()
+	s.robots[link.Host] = rules
+	s.robotsMu.Unlock()
+	return rules, nil
+}
+
+// hostLimiter spaces the requests to each host
+type hostLimiter struct {
+	delay   time.Duration
+	mu      sync.Mutex
+	nextAt  map[string]time.Time
+	delayOf func(host string) time.Duration
+}
+
+func newHostLimiter(delay time.Duration, delayOf func(host string) time.Duration) *hostLimiter {
+	return &hostLimiter{
+		delay:   delay,
+		nextAt:  make(map[string]time.Time),
+		delayOf: delayOf,
+	}
+}
+
+// wait blocks until a request can be made to the host, or the context is done
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	delay := l.delay
+	if hostDelay := l.delayOf(host); hostDelay > delay {
+		delay = hostDelay
+	}
+	if delay > maxCrawlDelay {
+		delay = maxCrawlDelay
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.nextAt[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.nextAt[host] = at.Add(delay)
+	l.mu.Unlock()
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// politeTransport waits for the host limiter before each request, so only requests that are not served
+// from the cache are limited. The timeout starts after the wait, so waiting for a slow host does not time out the request.
+type politeTransport struct {
+	base    http.RoundTripper
+	limiter *hostLimiter
+	timeout time.Duration
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.limiter.wait(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the timeout covers reading the body too, so it is only cancelled when the body is closed
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose cancels the context of a request when its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
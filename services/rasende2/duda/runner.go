@@ -1,28 +1,15 @@
 package duda
 
-import (
-	"log"
-	"strings"
-)
-
-func PrintPotentialRssFeedSites(cache *Cache) {
-	dudaScraper := NewScraper(cache)
+// DiscoverMediaFeeds finds the feeds of the media listed on duda.dk
+func DiscoverMediaFeeds(cache *Cache, options ScraperOptions) ([]DiscoveredFeed, error) {
+	dudaScraper := NewScraper(cache, options)
 	links, err := dudaScraper.GetMediaUrls()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	workingLinks, err := dudaScraper.DownloadContents(links)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	for i, link := range workingLinks {
-		content, err := dudaScraper.GetContent(link)
-		if err != nil {
-			log.Printf("error getting content for %v: %v", link.Url, err)
-		}
-		if strings.Contains(content, "rss") {
-			log.Printf("(%v) %v: %v", i, link.Url, len(content))
-		}
+		return nil, err
 	}
+	return dudaScraper.DiscoverFeeds(workingLinks), nil
 }
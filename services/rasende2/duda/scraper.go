@@ -2,18 +2,31 @@ package duda
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
+const (
+	// pageTTL is how long pages are cached, since the media on duda.dk rarely change
+	pageTTL        = 7 * 24 * time.Hour
+	maxPageSize    = 10 * 1024 * 1024
+	requestTimeout = 30 * time.Second
+
+	DefaultUserAgent = "rasende2-duda/1.0 (+https://rasende2-api.bjarke.xyz)"
+)
+
 var (
-	chromeUserAgent       = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/103.0.0.0 Safari/537.36"
 	disabledSitesCacheKey = "disabled-sites"
+	// ErrDisallowed is returned for urls that robots.txt does not allow crawling
+	ErrDisallowed = errors.New("disallowed by robots.txt")
 )
 
 type Link struct {
@@ -21,25 +34,86 @@ type Link struct {
 	Title string
 }
 
+// disabledSite is a site that failed on every attempt, and is not crawled again until RecheckAt
 type disabledSite struct {
-	Reason string
+	Reason        string
+	Failures      int
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+	RecheckAt     time.Time
+}
+
+// ScraperOptions configure how polite the scraper is. Zero values are replaced by the defaults.
+type ScraperOptions struct {
+	// UserAgent is sent with every request, and matched against the groups of robots.txt
+	UserAgent string
+	// Concurrency is the number of sites crawled at the same time
+	Concurrency int
+	// HostDelay is the minimum time between requests to the same host. A longer Crawl-delay in robots.txt is respected.
+	HostDelay time.Duration
+	// MaxAttempts is the number of attempts to get a site, before it is disabled
+	MaxAttempts int
+	// RetryDelay is the wait before the second attempt, and doubles for each attempt
+	RetryDelay time.Duration
+	// RecheckAfter is how long a site is disabled, before it is tried again
+	RecheckAfter time.Duration
 }
 
 type Scraper struct {
-	cache *Cache
+	cache    *Cache
+	client   *http.Client
+	options  ScraperOptions
+	robotsMu sync.Mutex
+	robots   map[string]*robotsRules
+}
+
+func NewScraper(cache *Cache, options ScraperOptions) *Scraper {
+	if options.UserAgent == "" {
+		options.UserAgent = DefaultUserAgent
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 4
+	}
+	if options.HostDelay <= 0 {
+		options.HostDelay = 2 * time.Second
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 3
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = 5 * time.Second
+	}
+	if options.RecheckAfter <= 0 {
+		options.RecheckAfter = 30 * 24 * time.Hour
+	}
+	s := &Scraper{
+		cache:   cache,
+		options: options,
+		robots:  make(map[string]*robotsRules),
+	}
+	limiter := newHostLimiter(options.HostDelay, s.crawlDelay)
+	// the timeout is set by the transport, since http.Client.Timeout would include the wait for the host
+	s.client = &http.Client{
+		Transport: &politeTransport{base: http.DefaultTransport, limiter: limiter, timeout: requestTimeout},
+	}
+	return s
 }
 
-func NewScraper(cache *Cache) *Scraper {
-	return &Scraper{
-		cache: cache,
+// crawlDelay returns the Crawl-delay of robots.txt of the host, if it has been fetched
+func (s *Scraper) crawlDelay(host string) time.Duration {
+	s.robotsMu.Lock()
+	defer s.robotsMu.Unlock()
+	if rules, ok := s.robots[host]; ok {
+		return rules.crawlDelay
 	}
+	return 0
 }
 
 func (s *Scraper) getDisabledSites() (map[string]disabledSite, error) {
 	disabledSites := make(map[string]disabledSite)
-	disabledSitesJson, ok := s.cache.Get(disabledSitesCacheKey)
+	disabledSitesJson, ok := s.cache.GetValue(disabledSitesCacheKey)
 	if ok {
-		err := json.Unmarshal([]byte(disabledSitesJson), &disabledSites)
+		err := json.Unmarshal(disabledSitesJson, &disabledSites)
 		if err != nil {
 			return nil, fmt.Errorf("could not unmarshal disabled sites: %w", err)
 		}
@@ -48,63 +122,149 @@ func (s *Scraper) getDisabledSites() (map[string]disabledSite, error) {
 }
 
 func (s *Scraper) saveDisabledSites(disabledSites map[string]disabledSite) error {
-	disabledSitesBytes, err := json.Marshal(disabledSites)
+	disabledSitesJson, err := json.Marshal(disabledSites)
 	if err != nil {
 		return fmt.Errorf("error marshaling disabled sites: %w", err)
 	}
-	disabledSitesJson := string(disabledSitesBytes)
-	s.cache.Put(disabledSitesCacheKey, disabledSitesJson)
-	return nil
+	return s.cache.PutValue(disabledSitesCacheKey, disabledSitesJson)
 }
 
-func (s *Scraper) GetContent(link Link) (string, error) {
-	cacheKey := "links/" + strings.ReplaceAll(link.Url, "/", "_")
-	content, ok := s.cache.Get(cacheKey)
-	if ok {
-		return content, nil
+// statusError is returned when a page does not return 200
+type statusError struct {
+	url        string
+	statusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%v returned non-200: %v", e.url, e.statusCode)
+}
+
+// isRetryable returns whether the error can be temporary. Network errors, rate limits and server errors are.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrDisallowed) {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode == http.StatusTooManyRequests || statusErr.statusCode >= 500
 	}
-	req, err := http.NewRequest("GET", link.Url, nil)
+	return true
+}
+
+// fetch gets the url through the cache, if robots.txt allows it, and returns an error if the response is not 200
+func (s *Scraper) fetch(link string, accept string) (string, error) {
+	parsed, err := url.Parse(link)
 	if err != nil {
-		log.Printf("error creating request: %v", err)
+		return "", fmt.Errorf("invalid url %v: %w", link, err)
 	}
-	req.Header.Set("User-Agent", chromeUserAgent)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	robots, err := s.getRobots(parsed)
 	if err != nil {
-		return "", fmt.Errorf("error getting %v: %w", link.Url, err)
+		return "", err
 	}
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("%v returned non-200: %v", link.Url, resp.StatusCode)
+	if !robots.allowed(parsed.EscapedPath()) {
+		return "", fmt.Errorf("%v: %w", link, ErrDisallowed)
+	}
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	req.Header.Set("User-Agent", s.options.UserAgent)
+	req.Header.Set("Accept", accept)
+	entry, err := s.cache.Fetch(s.client, req, pageTTL, maxPageSize)
 	if err != nil {
-		return "", fmt.Errorf("error reading body: %w", err)
+		return "", err
 	}
-	bodyStr := string(body)
-	s.cache.Put(cacheKey, bodyStr)
-	return bodyStr, nil
+	if entry.StatusCode != http.StatusOK {
+		return "", &statusError{url: link, statusCode: entry.StatusCode}
+	}
+	return string(entry.Body), nil
 }
 
-func (s *Scraper) DownloadContents(links []Link) ([]Link, error) {
+func (s *Scraper) GetContent(link Link) (string, error) {
+	return s.fetch(link.Url, "*/*")
+}
+
+// getContentWithRetries gets the content, and retries temporary errors with exponential backoff
+func (s *Scraper) getContentWithRetries(link Link) error {
+	delay := s.options.RetryDelay
+	var err error
+	for attempt := 1; attempt <= s.options.MaxAttempts; attempt++ {
+		_, err = s.GetContent(link)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt < s.options.MaxAttempts {
+			log.Printf("attempt %v of getting %v failed, retrying in %v: %v", attempt, link.Url, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
 
+// forEach calls fn with the numbers from 0 to n, on a bounded number of workers
+func (s *Scraper) forEach(n int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < s.options.Concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// DownloadContents gets the content of the links with a bounded number of workers, and returns the links that
+// worked. Links that fail on every attempt are disabled, and are not tried again until their re-check date.
+func (s *Scraper) DownloadContents(links []Link) ([]Link, error) {
 	disabledSites, err := s.getDisabledSites()
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
+	errs := make([]error, len(links))
+	skipped := make([]bool, len(links))
+	for i, link := range links {
+		if site, ok := disabledSites[link.Url]; ok && now.Before(site.RecheckAt) {
+			skipped[i] = true
+		}
+	}
+	s.forEach(len(links), func(i int) {
+		if !skipped[i] {
+			errs[i] = s.getContentWithRetries(links[i])
+		}
+	})
+
 	workingLinks := make([]Link, 0)
-	for _, link := range links {
-		if _, ok := disabledSites[link.Url]; ok {
+	for i, link := range links {
+		if skipped[i] {
 			continue
 		}
-		_, err := s.GetContent(link)
-		if err != nil {
-			log.Printf("error getting %v: %v\n", link.Url, err)
-			disabledSites[link.Url] = disabledSite{Reason: fmt.Sprintf("error getting: %v", err)}
+		if errs[i] == nil {
+			delete(disabledSites, link.Url)
+			workingLinks = append(workingLinks, link)
 			continue
 		}
-		workingLinks = append(workingLinks, link)
+		log.Printf("error getting %v, disabling it: %v", link.Url, errs[i])
+		site := disabledSites[link.Url]
+		if site.Failures == 0 {
+			site.FirstFailedAt = now
+		}
+		site.Failures++
+		site.Reason = errs[i].Error()
+		site.LastFailedAt = now
+		// sites that keep failing are checked less often
+		site.RecheckAt = now.Add(s.options.RecheckAfter * time.Duration(site.Failures))
+		disabledSites[link.Url] = site
 	}
 	err = s.saveDisabledSites(disabledSites)
 	if err != nil {
@@ -117,32 +277,9 @@ func (s *Scraper) GetMediaUrls() ([]Link, error) {
 
 	duda := "https://duda.dk/aviser/"
 
-	cacheKey := "dudahtml"
-	cachedHtml, ok := s.cache.Get(cacheKey)
-	if !ok {
-		log.Println("duda not found in cache, getting")
-		client := &http.Client{}
-		req, err := http.NewRequest("GET", duda, nil)
-		if err != nil {
-			return nil, fmt.Errorf("could not create request: %w", err)
-		}
-		req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8")
-		req.Header.Set("User-Agent", chromeUserAgent)
-		res, err := client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("could not do request: %w", err)
-		}
-		if res.StatusCode != 200 {
-			return nil, fmt.Errorf("request returned %v", res.StatusCode)
-		}
-
-		defer res.Body.Close()
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, fmt.Errorf("could not read body: %w", err)
-		}
-		cachedHtml = string(body)
-		s.cache.Put(cacheKey, cachedHtml)
+	cachedHtml, err := s.fetch(duda, "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8")
+	if err != nil {
+		return nil, err
 	}
 
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(cachedHtml))
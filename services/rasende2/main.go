@@ -2,11 +2,14 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/bjarke-xyz/go-monorepo/libs/common"
 	"github.com/bjarke-xyz/go-monorepo/libs/common/config"
 	"github.com/bjarke-xyz/go-monorepo/libs/common/db"
 	"github.com/bjarke-xyz/go-monorepo/libs/common/jobs"
+	"github.com/bjarke-xyz/go-monorepo/libs/common/storage"
+	"github.com/bjarke-xyz/rasende2/duda"
 	"github.com/bjarke-xyz/rasende2/pkg"
 	"github.com/bjarke-xyz/rasende2/rss"
 )
@@ -28,24 +31,82 @@ func main() {
 		Cache:      db.NewRedisCache(cfg),
 		Config:     cfg,
 		JobManager: *jobs.NewJobManager(),
+		Storage:    storage.NewStorageClient(cfg),
 	}
 
 	rssRepository := rss.NewRssRepository(context)
-	rssService := rss.NewRssService(context, rssRepository)
+	scorer, err := rss.NewDefaultScorer("")
+	if err != nil {
+		log.Panicf("failed to load scorer: %v", err)
+	}
+	var httpCache *duda.Cache
+	if httpCacheDir := os.Getenv("HTTP_CACHE_DIR"); httpCacheDir != "" {
+		httpCache = duda.NewCache(httpCacheDir, duda.CacheOptions{})
+	}
+	rssService := rss.NewRssService(context, rssRepository, scorer, httpCache)
+
+	isCommand, err := runCli(rssService, os.Args[1:])
+	if isCommand {
+		if err != nil {
+			log.Fatalf("command failed: %v", err)
+		}
+		return
+	}
+
+	err = rssService.ImportSourcesIfEmpty("rss.json")
+	if err != nil {
+		log.Printf("failed to import sources from rss.json: %v", err)
+	}
 
 	defer context.JobManager.Stop()
-	context.JobManager.Cron("1 * * * *", rss.JobIdentifierIngestion, func() error {
+	// Sources are only fetched when their polling interval has passed
+	context.JobManager.Cron("*/5 * * * *", rss.JobIdentifierIngestion, func() error {
 		job := rss.NewIngestionJob(rssService)
 		return job.ExecuteJob()
 	}, cfg.AppEnv == config.AppEnvProduction)
+	context.JobManager.Cron("30 * * * *", rss.JobIdentifierTermFrequencies, func() error {
+		job := rss.NewTermFrequencyJob(rssService)
+		return job.ExecuteJob()
+	}, cfg.AppEnv == config.AppEnvProduction)
+	context.JobManager.Cron("*/15 * * * *", rss.JobIdentifierArticleExtraction, func() error {
+		job := rss.NewArticleExtractionJob(rssService)
+		return job.ExecuteJob()
+	}, cfg.AppEnv == config.AppEnvProduction)
+	context.JobManager.Cron("45 * * * *", rss.JobIdentifierScoring, func() error {
+		job := rss.NewScoringJob(rssService)
+		return job.ExecuteJob()
+	}, cfg.AppEnv == config.AppEnvProduction)
+	// Content is archived at night, when few items are ingested
+	context.JobManager.Cron("15 3 * * *", rss.JobIdentifierRetention, func() error {
+		job := rss.NewRetentionJob(rssService)
+		return job.ExecuteJob()
+	}, cfg.AppEnv == config.AppEnvProduction)
 	go context.JobManager.Start()
 
-	rssHttpHandlers := rss.NewHttpHandlers(context, rssService)
+	itemStream := rss.NewItemStream(rssService)
+	go itemStream.Run()
+
+	rssHttpHandlers := rss.NewHttpHandlers(context, rssService, itemStream)
 
 	r := common.GinRouter(cfg)
 	r.GET("/search", rssHttpHandlers.HandleSearch)
 	r.GET("/charts", rssHttpHandlers.HandleCharts)
+	r.GET("/charts/anger", rssHttpHandlers.HandleAngerCharts)
+	r.GET("/feeds/search", rssHttpHandlers.HandleSearchFeed)
+	r.GET("/stream", rssHttpHandlers.HandleStream)
+	r.GET("/trending", rssHttpHandlers.HandleTrending)
+	r.GET("/leaderboard", rssHttpHandlers.HandleRageLeaderboard)
+	r.GET("/cooccurring", rssHttpHandlers.HandleCooccurring)
 	r.POST("/job", rssHttpHandlers.RunJob(cfg.JobKey))
+	r.GET("/cache/stats", rssHttpHandlers.RequireKey(cfg.JobKey), rssHttpHandlers.HandleCacheStats)
+
+	sources := r.Group("/sources", rssHttpHandlers.RequireKey(cfg.JobKey))
+	sources.GET("", rssHttpHandlers.HandleGetSources)
+	sources.POST("", rssHttpHandlers.HandleCreateSource)
+	sources.GET("/health", rssHttpHandlers.HandleGetSourcesHealth)
+	sources.GET("/:id", rssHttpHandlers.HandleGetSource)
+	sources.PUT("/:id", rssHttpHandlers.HandleUpdateSource)
+	sources.DELETE("/:id", rssHttpHandlers.HandleDeleteSource)
 
 	r.Run()
 
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS rss_sources;
\ No newline at end of file
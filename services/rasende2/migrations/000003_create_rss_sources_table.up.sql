@@ -0,0 +1,11 @@
+create table if not exists rss_sources(
+    id serial primary key,
+    name text not null unique,
+    urls text[] not null,
+    categories text[] not null default '{}',
+    enabled boolean not null default true,
+    polling_interval_minutes int not null default 60,
+    last_polled timestamptz,
+    created_at timestamptz not null default now(),
+    updated_at timestamptz not null default now()
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS rss_fetch_validators;
\ No newline at end of file
@@ -0,0 +1,6 @@
+create table if not exists rss_fetch_validators(
+    url text primary key,
+    etag text not null default '',
+    last_modified text not null default '',
+    updated_at timestamptz not null default now()
+);
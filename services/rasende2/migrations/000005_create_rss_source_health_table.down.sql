@@ -0,0 +1 @@
+DROP TABLE IF EXISTS rss_source_health;
\ No newline at end of file
@@ -0,0 +1,12 @@
+create table if not exists rss_source_health(
+    source_id int primary key references rss_sources(id) on delete cascade,
+    last_success timestamptz,
+    last_failure timestamptz,
+    consecutive_failures int not null default 0,
+    last_error text not null default '',
+    last_item_count int not null default 0,
+    last_new_item_count int not null default 0,
+    last_new_items_at timestamptz,
+    next_attempt timestamptz,
+    auto_disabled boolean not null default false
+);
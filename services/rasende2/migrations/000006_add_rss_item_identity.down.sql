@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS rss_item_revisions;
+DROP INDEX IF EXISTS rss_items_missing_canonical_link_idx;
+DROP INDEX IF EXISTS rss_items_published_idx;
+DROP INDEX IF EXISTS rss_items_cluster_id_idx;
+DROP INDEX IF EXISTS rss_items_site_name_guid_idx;
+DROP INDEX IF EXISTS rss_items_site_name_canonical_link_idx;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS minhash;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS cluster_id;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS canonical_link;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS guid;
\ No newline at end of file
@@ -0,0 +1,23 @@
+alter table rss_items add column if not exists guid text not null default '';
+alter table rss_items add column if not exists canonical_link text not null default '';
+-- items with the same cluster_id are near duplicates of the same story
+alter table rss_items add column if not exists cluster_id text not null default '';
+alter table rss_items add column if not exists minhash bigint[];
+
+update rss_items set cluster_id = item_id where cluster_id = '';
+
+create index if not exists rss_items_site_name_canonical_link_idx on rss_items(site_name, canonical_link);
+create index if not exists rss_items_site_name_guid_idx on rss_items(site_name, guid);
+create index if not exists rss_items_cluster_id_idx on rss_items(cluster_id);
+create index if not exists rss_items_published_idx on rss_items(published);
+-- the canonical links of existing items are computed by the ingestion job with canonicalLink, which finds them with this index
+create index if not exists rss_items_missing_canonical_link_idx on rss_items(item_id) where canonical_link = '';
+
+create table if not exists rss_item_revisions(
+    id serial primary key,
+    item_id text not null references rss_items(item_id) on delete cascade,
+    title text not null,
+    link text,
+    changed_at timestamptz not null default now()
+);
+create index if not exists rss_item_revisions_item_id_idx on rss_item_revisions(item_id);
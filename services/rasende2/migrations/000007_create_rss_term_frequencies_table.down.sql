@@ -0,0 +1 @@
+DROP TABLE IF EXISTS rss_term_frequencies;
\ No newline at end of file
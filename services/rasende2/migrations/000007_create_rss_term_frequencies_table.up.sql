@@ -0,0 +1,10 @@
+-- number of items per site per day with each lexeme in the title and content, computed by the term frequencies job
+create table if not exists rss_term_frequencies(
+    day date not null,
+    site_name text not null,
+    term text not null,
+    title_count int not null default 0,
+    content_count int not null default 0,
+    primary key (day, site_name, term)
+);
+create index if not exists rss_term_frequencies_term_day_idx on rss_term_frequencies(term, day);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS ts_content_idx;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS ts_content;
+ALTER TABLE rss_items ADD COLUMN ts_content tsvector GENERATED ALWAYS AS (to_tsvector('danish', content)) STORED;
+CREATE INDEX IF NOT EXISTS ts_content_idx ON rss_items USING GIN(ts_content);
+ALTER TABLE rss_items DROP COLUMN IF EXISTS article_error;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS article_fetched_at;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS paywalled;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS article_word_count;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS article_text;
+ALTER TABLE rss_sources DROP COLUMN IF EXISTS extract_articles;
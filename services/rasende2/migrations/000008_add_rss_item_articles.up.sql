@@ -0,0 +1,15 @@
+-- articles are only extracted for sources where it is enabled
+alter table rss_sources add column if not exists extract_articles boolean not null default false;
+
+alter table rss_items add column if not exists article_text text;
+alter table rss_items add column if not exists article_word_count int not null default 0;
+alter table rss_items add column if not exists paywalled boolean not null default false;
+alter table rss_items add column if not exists article_fetched_at timestamptz;
+alter table rss_items add column if not exists article_error text not null default '';
+
+-- content search also searches the extracted article
+drop index if exists ts_content_idx;
+alter table rss_items drop column if exists ts_content;
+alter table rss_items add column ts_content tsvector
+    generated always as (to_tsvector('danish', coalesce(content, '') || ' ' || coalesce(article_text, ''))) stored;
+create index if not exists ts_content_idx on rss_items using GIN(ts_content);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS rss_items_scorer_idx;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS scorer;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS negativity;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS anger;
@@ -0,0 +1,5 @@
+-- anger and negativity are scored from 0 to 1 at ingest, scorer is the name of the scorer, or empty if the item has not been scored
+alter table rss_items add column if not exists anger real not null default 0;
+alter table rss_items add column if not exists negativity real not null default 0;
+alter table rss_items add column if not exists scorer text not null default '';
+create index if not exists rss_items_scorer_idx on rss_items(scorer);
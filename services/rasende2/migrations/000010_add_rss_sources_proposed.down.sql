@@ -0,0 +1 @@
+ALTER TABLE rss_sources DROP COLUMN IF EXISTS proposed;
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- proposed sources are found by feed discovery, and are disabled until they have been reviewed
+alter table rss_sources add column if not exists proposed boolean not null default false;
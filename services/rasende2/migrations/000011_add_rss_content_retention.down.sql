@@ -0,0 +1,2 @@
+ALTER TABLE rss_items DROP COLUMN IF EXISTS content_pruned_at;
+ALTER TABLE rss_sources DROP COLUMN IF EXISTS content_retention_months;
@@ -0,0 +1,4 @@
+-- the content of items older than content_retention_months is archived and removed. 0 keeps it forever.
+alter table rss_sources add column if not exists content_retention_months int not null default 0;
+-- content_pruned_at is set when the content of an item has been archived and removed
+alter table rss_items add column if not exists content_pruned_at timestamptz;
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS ts_title_idx;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS ts_title;
+ALTER TABLE rss_items ADD COLUMN ts_title tsvector GENERATED ALWAYS AS (to_tsvector('danish', title)) STORED;
+CREATE INDEX IF NOT EXISTS ts_title_idx ON rss_items USING GIN(ts_title);
+DROP INDEX IF EXISTS ts_content_idx;
+ALTER TABLE rss_items DROP COLUMN IF EXISTS ts_content;
+ALTER TABLE rss_items ADD COLUMN ts_content tsvector GENERATED ALWAYS AS (to_tsvector('danish', coalesce(content, '') || ' ' || coalesce(article_text, ''))) STORED;
+CREATE INDEX IF NOT EXISTS ts_content_idx ON rss_items USING GIN(ts_content);
+DROP FUNCTION IF EXISTS rss_search_config(text);
+ALTER TABLE rss_items DROP COLUMN IF EXISTS language;
+ALTER TABLE rss_sources DROP COLUMN IF EXISTS language;
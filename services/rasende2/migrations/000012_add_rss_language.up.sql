@@ -0,0 +1,21 @@
+-- the language of a source decides the text search configuration of the search vectors of its items
+alter table rss_sources add column if not exists language text not null default 'da';
+alter table rss_items add column if not exists language text not null default 'da';
+
+-- rss_search_config is the text search configuration of a language. It is used by the generated columns
+-- and by the queries, so the languages are only mapped here.
+create or replace function rss_search_config(lang text) returns regconfig
+    language sql immutable parallel safe
+    as $$ select (case lang when 'sv' then 'swedish' when 'no' then 'norwegian' when 'en' then 'english' else 'danish' end)::regconfig $$;
+
+drop index if exists ts_title_idx;
+alter table rss_items drop column if exists ts_title;
+alter table rss_items add column ts_title tsvector
+    generated always as (to_tsvector(rss_search_config(language), title)) stored;
+create index if not exists ts_title_idx on rss_items using GIN(ts_title);
+
+drop index if exists ts_content_idx;
+alter table rss_items drop column if exists ts_content;
+alter table rss_items add column ts_content tsvector
+    generated always as (to_tsvector(rss_search_config(language), coalesce(content, '') || ' ' || coalesce(article_text, ''))) stored;
+create index if not exists ts_content_idx on rss_items using GIN(ts_content);
@@ -0,0 +1 @@
+alter table rss_items alter column published type timestamp using published at time zone 'UTC';
@@ -0,0 +1,3 @@
+-- published was stored without a time zone, as the wall clock time the feed gave. The feeds are Danish, so the stored
+-- times are read as Danish time. Items from feeds that gave their times in another offset are off by the difference.
+alter table rss_items alter column published type timestamptz using published at time zone 'Europe/Copenhagen';
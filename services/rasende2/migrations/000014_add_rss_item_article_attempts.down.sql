@@ -0,0 +1 @@
+alter table rss_items drop column if exists article_attempts;
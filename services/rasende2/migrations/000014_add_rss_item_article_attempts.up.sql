@@ -0,0 +1,2 @@
+-- articles that failed with a temporary error are fetched again, until they have been attempted too many times
+alter table rss_items add column if not exists article_attempts int not null default 0;
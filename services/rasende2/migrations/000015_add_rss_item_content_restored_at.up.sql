@@ -0,0 +1,3 @@
+-- content_restored_at is set when the archived content of an item has been restored, and keeps the retention job
+-- from pruning the item again
+alter table rss_items add column if not exists content_restored_at timestamptz;
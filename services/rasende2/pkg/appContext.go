@@ -4,10 +4,12 @@ import (
 	"github.com/bjarke-xyz/go-monorepo/libs/common/config"
 	"github.com/bjarke-xyz/go-monorepo/libs/common/db"
 	"github.com/bjarke-xyz/go-monorepo/libs/common/jobs"
+	"github.com/bjarke-xyz/go-monorepo/libs/common/storage"
 )
 
 type AppContext struct {
 	Cache      *db.RedisCache
 	Config     *config.Config
 	JobManager jobs.JobManager
+	Storage    *storage.StorageClient
 }
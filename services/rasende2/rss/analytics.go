@@ -0,0 +1,238 @@
+package rss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	JobIdentifierTermFrequencies = "RASENDE2_TERM_FREQUENCIES_JOB"
+
+	// termFrequencyBackfillDays is the number of days computed when there are no term frequencies yet
+	termFrequencyBackfillDays = 60
+	// termFrequencyRecomputeDays is the number of days recomputed on each run, yesterday gets items published late
+	termFrequencyRecomputeDays = 2
+
+	// minTrendingCount is the number of recent mentions a term needs to be trending, so rare words are not spikes
+	minTrendingCount    = 5
+	defaultTrendingDays = 1
+	// defaultBaselineDays is the number of days before the recent days, the recent mentions are compared to
+	defaultBaselineDays = 28
+	maxAnalyticsDays    = 365
+	defaultTermLimit    = 20
+	maxTermLimit        = 100
+
+	// rageQuery is what makes an outlet rasende
+	rageQuery               = "'rasende'"
+	defaultLeaderboardWeeks = 8
+)
+
+var ErrInvalidAnalytics = errors.New("invalid analytics request")
+
+type TermFrequencyJob struct {
+	service *RssService
+}
+
+func NewTermFrequencyJob(service *RssService) *TermFrequencyJob {
+	return &TermFrequencyJob{
+		service: service,
+	}
+}
+
+func (t *TermFrequencyJob) ExecuteJob() error {
+	return t.service.ComputeTermFrequencies(time.Now())
+}
+
+// ComputeTermFrequencies computes the term frequencies of the last days, or backfills them if there are none
+func (r *RssService) ComputeTermFrequencies(now time.Time) error {
+	latest, err := r.repository.GetLatestTermFrequencyDay()
+	if err != nil {
+		return err
+	}
+	days := termFrequencyRecomputeDays
+	if latest == nil {
+		days = termFrequencyBackfillDays
+	} else if missing := int(now.Sub(*latest).Hours()/24) + 1; missing > days {
+		// the job has not run for a while
+		days = missing
+		if days > termFrequencyBackfillDays {
+			days = termFrequencyBackfillDays
+		}
+	}
+	today := now.In(chartLocation)
+	for i := days - 1; i >= 0; i-- {
+		day := time.Date(today.Year(), today.Month(), today.Day()-i, 0, 0, 0, 0, chartLocation)
+		count, err := r.repository.ComputeTermFrequencies(day, day.AddDate(0, 0, 1))
+		if err != nil {
+			return fmt.Errorf("failed to compute term frequencies for %v: %w", day.Format("2006-01-02"), err)
+		}
+		log.Printf("computed %v term frequencies for %v", count, day.Format("2006-01-02"))
+	}
+	r.invalidateCache(context.Background())
+	return nil
+}
+
+type TrendingParams struct {
+	// Days is the number of recent days, including today
+	Days         int
+	BaselineDays int
+	Site         string
+	// SearchContent also counts the terms in the content
+	SearchContent bool
+	Limit         int
+}
+
+type TrendingTerm struct {
+	Term          string `db:"term" json:"term"`
+	RecentCount   int    `db:"recent_count" json:"recentCount"`
+	BaselineCount int    `db:"baseline_count" json:"baselineCount"`
+	// Score is how many times more the term is mentioned per day recently than in the baseline
+	Score float64 `db:"score" json:"score"`
+}
+
+func validateTermLimit(limit int) int {
+	if limit <= 0 {
+		return defaultTermLimit
+	}
+	if limit > maxTermLimit {
+		return maxTermLimit
+	}
+	return limit
+}
+
+func (p TrendingParams) validate() (TrendingParams, error) {
+	if p.Days == 0 {
+		p.Days = defaultTrendingDays
+	}
+	if p.BaselineDays == 0 {
+		p.BaselineDays = defaultBaselineDays
+	}
+	if p.Days < 0 || p.BaselineDays < 0 || p.Days+p.BaselineDays > maxAnalyticsDays {
+		return p, fmt.Errorf("%w: days and baseline must be positive, and at most %v days in total", ErrInvalidAnalytics, maxAnalyticsDays)
+	}
+	p.Limit = validateTermLimit(p.Limit)
+	return p, nil
+}
+
+// GetTrendingTerms returns the terms that are mentioned the most recently, compared to the baseline before
+func (r *RssService) GetTrendingTerms(ctx context.Context, params TrendingParams) ([]TrendingTerm, error) {
+	params, err := params.validate()
+	if err != nil {
+		return nil, err
+	}
+	terms := []TrendingTerm{}
+	cacheKey := r.cacheKey(ctx, fmt.Sprintf("TrendingTerms:%v:%v:%v:%v:%v", params.Days, params.BaselineDays, params.Site, params.SearchContent, params.Limit))
+	if err := r.context.Cache.Get(ctx, cacheKey, &terms); err == nil {
+		return terms, nil
+	}
+	today := time.Now().In(chartLocation)
+	recentStart := time.Date(today.Year(), today.Month(), today.Day()-(params.Days-1), 0, 0, 0, 0, time.UTC)
+	baselineStart := recentStart.AddDate(0, 0, -params.BaselineDays)
+	terms, err = r.repository.GetTrendingTerms(params, baselineStart, recentStart)
+	if err != nil {
+		return nil, err
+	}
+	r.context.Cache.Set(ctx, cacheKey, terms, time.Hour)
+	return terms, nil
+}
+
+type SiteRage struct {
+	SiteName  string `db:"site_name" json:"siteName"`
+	RageCount int    `db:"rage_count" json:"rageCount"`
+	ItemCount int    `db:"item_count" json:"itemCount"`
+	// Share is the share of the items of the site that were rasende
+	Share float64 `json:"share"`
+}
+
+type RageWeek struct {
+	// Week is the monday the week starts on
+	Week  string     `json:"week"`
+	Sites []SiteRage `json:"sites"`
+}
+
+// RageCount is the number of rasende items at a site in a week
+type RageCount struct {
+	Week time.Time `db:"week"`
+	SiteRage
+}
+
+// GetRageLeaderboard returns the sites ordered by the number of rasende headlines, for each of the last weeks
+func (r *RssService) GetRageLeaderboard(ctx context.Context, weeks int) ([]RageWeek, error) {
+	if weeks == 0 {
+		weeks = defaultLeaderboardWeeks
+	}
+	if weeks < 0 || weeks*7 > maxAnalyticsDays {
+		return nil, fmt.Errorf("%w: weeks must be between 1 and %v", ErrInvalidAnalytics, maxAnalyticsDays/7)
+	}
+	leaderboard := []RageWeek{}
+	cacheKey := r.cacheKey(ctx, fmt.Sprintf("RageLeaderboard:%v", weeks))
+	if err := r.context.Cache.Get(ctx, cacheKey, &leaderboard); err == nil {
+		return leaderboard, nil
+	}
+	params := ChartParams{Bucket: ChartBucketWeek}
+	from := params.truncate(time.Now()).AddDate(0, 0, -7*(weeks-1))
+	counts, err := r.repository.GetRageCounts(rageQuery, from)
+	if err != nil {
+		return nil, err
+	}
+	byWeek := make(map[string]*RageWeek)
+	for _, count := range counts {
+		week := count.Week.Format("2006-01-02")
+		if _, ok := byWeek[week]; !ok {
+			byWeek[week] = &RageWeek{Week: week, Sites: []SiteRage{}}
+			leaderboard = append(leaderboard, RageWeek{Week: week})
+		}
+		if count.ItemCount > 0 {
+			count.Share = float64(count.RageCount) / float64(count.ItemCount)
+		}
+		byWeek[week].Sites = append(byWeek[week].Sites, count.SiteRage)
+	}
+	for i := range leaderboard {
+		sites := byWeek[leaderboard[i].Week].Sites
+		sort.SliceStable(sites, func(a, b int) bool {
+			if sites[a].RageCount != sites[b].RageCount {
+				return sites[a].RageCount > sites[b].RageCount
+			}
+			return sites[a].Share > sites[b].Share
+		})
+		leaderboard[i].Sites = sites
+	}
+	r.context.Cache.Set(ctx, cacheKey, leaderboard, time.Hour)
+	return leaderboard, nil
+}
+
+type CooccurringTerm struct {
+	Term  string `db:"term" json:"term"`
+	Count int    `db:"count" json:"count"`
+}
+
+// GetCooccurringTerms returns the terms used the most in titles matching the query, in the last days
+func (r *RssService) GetCooccurringTerms(ctx context.Context, query string, days int, limit int) ([]CooccurringTerm, error) {
+	params, err := SearchParams{Query: query}.validate()
+	if err != nil {
+		return nil, err
+	}
+	if days == 0 {
+		days = defaultBaselineDays
+	}
+	if days < 0 || days > maxAnalyticsDays {
+		return nil, fmt.Errorf("%w: days must be between 1 and %v", ErrInvalidAnalytics, maxAnalyticsDays)
+	}
+	limit = validateTermLimit(limit)
+	terms := []CooccurringTerm{}
+	cacheKey := r.cacheKey(ctx, fmt.Sprintf("CooccurringTerms:%v:%v:%v", params.tsQuery, days, limit))
+	if err := r.context.Cache.Get(ctx, cacheKey, &terms); err == nil {
+		return terms, nil
+	}
+	from := time.Now().AddDate(0, 0, -days)
+	terms, err = r.repository.GetCooccurringTerms(params.tsQuery, params.Query, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	r.context.Cache.Set(ctx, cacheKey, terms, time.Hour)
+	return terms, nil
+}
@@ -0,0 +1,323 @@
+package rss
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+const (
+	JobIdentifierArticleExtraction = "RASENDE2_ARTICLE_EXTRACTION_JOB"
+
+	// articleBatchSize is the number of articles extracted in each run
+	articleBatchSize = 200
+	// articleConcurrency is lower than fetchConcurrency, since articles are fetched from fewer sites
+	articleConcurrency = 4
+	// articleMaxAge is how old items can be to get their article extracted, older articles are rarely changed
+	articleMaxAge  = 72 * time.Hour
+	maxArticleSize = 5 * 1024 * 1024
+	// minParagraphLength is the number of characters in a paragraph, for it to be article text and not a caption or button
+	minParagraphLength = 40
+	// paywallMaxWords is the number of words below which an article with paywall markers is considered paywalled
+	paywallMaxWords = 150
+	// maxArticleAttempts is the number of times an article is fetched, when it fails with temporary errors
+	maxArticleAttempts = 3
+)
+
+// paywallMarkers are texts that are shown instead of, or below the teaser of, paywalled articles
+var paywallMarkers = []string{
+	"kun for abonnenter",
+	"artiklen er forbeholdt abonnenter",
+	"bliv abonnent",
+	"køb abonnement",
+	"allerede abonnent",
+	"log ind for at læse",
+	"læs hele artiklen",
+	"fortsæt med at læse",
+}
+
+// paywallClassRegexp matches class names and ids used for paywalls
+var paywallClassRegexp = regexp.MustCompile(`(?i)paywall|premium-wall|subscriber-only|locked-content`)
+
+// nonArticleSelector are elements that are never part of the article text
+const nonArticleSelector = "script, style, noscript, iframe, nav, header, footer, aside, form, figure, figcaption, button, svg"
+
+type ItemArticle struct {
+	ItemId    string `db:"item_id"`
+	Link      string `db:"link"`
+	Text      string `db:"article_text"`
+	WordCount int    `db:"article_word_count"`
+	Paywalled bool   `db:"paywalled"`
+	// FetchedAt is nil until the article has been extracted, or has failed permanently
+	FetchedAt *time.Time `db:"article_fetched_at"`
+	Error     string     `db:"article_error"`
+	Attempts  int        `db:"article_attempts"`
+}
+
+// temporaryArticleError is returned for failures where fetching the article again later might succeed
+type temporaryArticleError struct {
+	err error
+}
+
+func (t *temporaryArticleError) Error() string {
+	return t.err.Error()
+}
+
+func (t *temporaryArticleError) Unwrap() error {
+	return t.err
+}
+
+type ArticleExtractionJob struct {
+	service *RssService
+}
+
+func NewArticleExtractionJob(service *RssService) *ArticleExtractionJob {
+	return &ArticleExtractionJob{
+		service: service,
+	}
+}
+
+func (a *ArticleExtractionJob) ExecuteJob() error {
+	return a.service.ExtractArticles(time.Now())
+}
+
+// ExtractArticles fetches and extracts the articles of recent items, from sources where it is enabled
+func (r *RssService) ExtractArticles(now time.Time) error {
+	items, err := r.repository.GetItemsWithoutArticle(now.Add(-articleMaxAge), articleBatchSize)
+	if err != nil {
+		return err
+	}
+	articles := make([]ItemArticle, len(items))
+	sem := make(chan struct{}, articleConcurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item ItemArticle) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			article, err := r.extractArticle(item)
+			article.Attempts = item.Attempts + 1
+			var temporary *temporaryArticleError
+			if err != nil {
+				article.Error = err.Error()
+			}
+			// articles that failed with a temporary error are fetched again on a later run, until they run out of attempts
+			if err == nil || !errors.As(err, &temporary) || article.Attempts >= maxArticleAttempts {
+				fetchedAt := time.Now()
+				article.FetchedAt = &fetchedAt
+			}
+			articles[i] = article
+		}(i, item)
+	}
+	wg.Wait()
+
+	failed := 0
+	retrying := 0
+	paywalled := 0
+	for _, article := range articles {
+		if article.Error != "" {
+			failed++
+			if article.FetchedAt == nil {
+				retrying++
+			}
+		}
+		if article.Paywalled {
+			paywalled++
+		}
+	}
+	log.Printf("ExtractArticles: extracted %v articles, %v paywalled, %v failed, %v of them will be retried", len(articles)-failed, paywalled, failed, retrying)
+	err = r.repository.SaveArticles(articles)
+	if err != nil {
+		return err
+	}
+	if len(articles) > failed {
+		r.invalidateCache(context.Background())
+	}
+	return nil
+}
+
+func (r *RssService) extractArticle(item ItemArticle) (ItemArticle, error) {
+	article := ItemArticle{ItemId: item.ItemId, Link: item.Link}
+	page, err := r.fetchArticle(item.Link)
+	if err != nil {
+		return article, err
+	}
+	text, paywalled, err := extractArticleText(page)
+	if err != nil {
+		return article, fmt.Errorf("failed to extract article from %v: %w", item.Link, err)
+	}
+	article.Text = text
+	article.WordCount = len(strings.Fields(text))
+	article.Paywalled = paywalled
+	return article, nil
+}
+
+func (r *RssService) fetchArticle(url string) (string, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "", fmt.Errorf("%q is not a http(s) url", url)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	req.Header.Set("Accept", "text/html, application/xhtml+xml;q=0.9")
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", &temporaryArticleError{fmt.Errorf("error getting %v: %w", url, err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		err := fmt.Errorf("error getting %v, returned error code %v", url, resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return "", &temporaryArticleError{err}
+		}
+		return "", err
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+			return "", fmt.Errorf("%v is not html, but %q", url, contentType)
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxArticleSize+1))
+	if err != nil {
+		return "", &temporaryArticleError{fmt.Errorf("error reading body of %v: %w", url, err)}
+	}
+	if len(body) > maxArticleSize {
+		return "", fmt.Errorf("body of %v is larger than %v bytes", url, maxArticleSize)
+	}
+	return string(body), nil
+}
+
+// extractArticleText finds the main text of an article page, readability-style: the element with the most paragraph text
+// is the article. It also returns whether the article is paywalled.
+func extractArticleText(page string) (string, bool, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		return "", false, err
+	}
+	paywalledByMetadata := isAccessibleForFree(doc) == "false"
+	hasPaywallElement := false
+	doc.Find("[class], [id]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		class, _ := s.Attr("class")
+		id, _ := s.Attr("id")
+		if paywallClassRegexp.MatchString(class + " " + id) {
+			hasPaywallElement = true
+			return false
+		}
+		return true
+	})
+	pageText := strings.ToLower(doc.Find("body").Text())
+	doc.Find(nonArticleSelector).Remove()
+
+	// Each paragraph scores its parent, and half for its grandparent, so a container of many paragraphs wins
+	scores := make(map[*html.Node]float64)
+	doc.Find("p").Each(func(i int, p *goquery.Selection) {
+		text := strings.TrimSpace(p.Text())
+		if len([]rune(text)) < minParagraphLength {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + float64(len([]rune(text)))/100
+		if parent := p.Get(0).Parent; parent != nil {
+			scores[parent] += score
+			if grandparent := parent.Parent; grandparent != nil {
+				scores[grandparent] += score / 2
+			}
+		}
+	})
+	var best *goquery.Selection
+	bestScore := 0.0
+	for node, score := range scores {
+		if score > bestScore {
+			best = doc.FindNodes(node)
+			bestScore = score
+		}
+	}
+	if best == nil {
+		if article := doc.Find("article").First(); article.Length() > 0 {
+			best = article
+		} else {
+			return "", paywalledByMetadata, nil
+		}
+	}
+
+	paragraphs := make([]string, 0)
+	best.Find("p, h2, h3, li").Each(func(i int, s *goquery.Selection) {
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text != "" && (goquery.NodeName(s) != "p" || len([]rune(text)) >= minParagraphLength) {
+			paragraphs = append(paragraphs, text)
+		}
+	})
+	text := strings.Join(paragraphs, "\n\n")
+
+	paywalled := paywalledByMetadata
+	if !paywalled && len(strings.Fields(text)) < paywallMaxWords {
+		if hasPaywallElement {
+			paywalled = true
+		}
+		for _, marker := range paywallMarkers {
+			if strings.Contains(pageText, marker) {
+				paywalled = true
+				break
+			}
+		}
+	}
+	return text, paywalled, nil
+}
+
+// isAccessibleForFree returns the isAccessibleForFree property of the schema.org metadata of the page, lowercased,
+// or an empty string if it is not set
+func isAccessibleForFree(doc *goquery.Document) string {
+	value := ""
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		var data interface{}
+		if json.Unmarshal([]byte(s.Text()), &data) != nil {
+			return true
+		}
+		value = findAccessibleForFree(data)
+		return value == ""
+	})
+	if value == "" {
+		if content, ok := doc.Find(`meta[itemprop="isAccessibleForFree"]`).Attr("content"); ok {
+			value = strings.ToLower(strings.TrimSpace(content))
+		}
+	}
+	return value
+}
+
+func findAccessibleForFree(data interface{}) string {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if value, ok := v["isAccessibleForFree"]; ok {
+			return strings.ToLower(fmt.Sprintf("%v", value))
+		}
+		for _, child := range v {
+			if value := findAccessibleForFree(child); value != "" {
+				return value
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if value := findAccessibleForFree(child); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
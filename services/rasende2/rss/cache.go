@@ -0,0 +1,33 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// cacheGenerationKey holds the generation of the cached results. It is bumped when items change,
+// so cached results are not used after new items are ingested, without waiting for them to expire.
+const cacheGenerationKey = "CacheGeneration"
+
+// cacheKey adds the current generation to the key, after its name
+func (r *RssService) cacheKey(ctx context.Context, key string) string {
+	generation, err := r.context.Cache.GetInt(ctx, cacheGenerationKey)
+	if err != nil {
+		// the cache is probably down, so the key does not matter
+		generation = 0
+	}
+	name, rest, _ := strings.Cut(key, ":")
+	return fmt.Sprintf("%v:g%v:%v", name, generation, rest)
+}
+
+// invalidateCache bumps the generation, so all cached results are stale
+func (r *RssService) invalidateCache(ctx context.Context) {
+	generation, err := r.context.Cache.Incr(ctx, cacheGenerationKey)
+	if err != nil {
+		log.Printf("failed to invalidate cache: %v", err)
+		return
+	}
+	log.Printf("cache generation is now %v", generation)
+}
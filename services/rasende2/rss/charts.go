@@ -0,0 +1,224 @@
+package rss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ChartBucketHour  = "hour"
+	ChartBucketDay   = "day"
+	ChartBucketWeek  = "week"
+	ChartBucketMonth = "month"
+
+	// chartTimezone is the timezone the buckets are in, so a day is a danish day
+	chartTimezone = "Europe/Copenhagen"
+	// maxChartBuckets limits the number of points on a line chart
+	maxChartBuckets = 1000
+	// maxChartQueries is the number of series that can be compared on one chart
+	maxChartQueries   = 5
+	defaultChartDays  = 7
+	defaultChartQuery = "rasende"
+)
+
+var ErrInvalidChart = errors.New("invalid chart")
+
+var chartLocation = mustLoadLocation(chartTimezone)
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load location %v: %v", name, err))
+	}
+	return location
+}
+
+type ChartParams struct {
+	Queries []string
+	// From and To are dates in chartTimezone, both inclusive. Zero means the last week.
+	From   time.Time
+	To     time.Time
+	Bucket string
+	// Unique counts each story once, even if it is syndicated to several sites
+	Unique bool
+	// tsQueries are the queries compiled by parseSearchQuery
+	tsQueries []string
+}
+
+// ChartCount is the number of items matching a query in a bucket, or at a site
+type ChartCount struct {
+	Bucket   time.Time `db:"bucket"`
+	SiteName string    `db:"site_name"`
+	Count    int       `db:"count"`
+}
+
+// start returns the first instant of from, in chartTimezone
+func (p ChartParams) start() time.Time {
+	return time.Date(p.From.Year(), p.From.Month(), p.From.Day(), 0, 0, 0, 0, chartLocation)
+}
+
+// end returns the first instant after to, in chartTimezone
+func (p ChartParams) end() time.Time {
+	return time.Date(p.To.Year(), p.To.Month(), p.To.Day()+1, 0, 0, 0, 0, chartLocation)
+}
+
+func (p ChartParams) validate(now time.Time) (ChartParams, error) {
+	if len(p.Queries) == 0 {
+		p.Queries = []string{defaultChartQuery}
+	}
+	if len(p.Queries) > maxChartQueries {
+		return p, fmt.Errorf("%w: at most %v queries can be compared", ErrInvalidChart, maxChartQueries)
+	}
+	p.tsQueries = make([]string, len(p.Queries))
+	for i, query := range p.Queries {
+		query = strings.TrimSpace(query)
+		if len(query) > 50 || len(query) <= 2 {
+			return p, fmt.Errorf("%w: query must be between 3 and 50 characters", ErrInvalidSearch)
+		}
+		tsQuery, err := parseSearchQuery(query)
+		if err != nil {
+			return p, err
+		}
+		p.Queries[i] = query
+		p.tsQueries[i] = tsQuery
+	}
+	return p.validatePeriod(now)
+}
+
+// validatePeriod sets the default period and bucket, and validates them
+func (p ChartParams) validatePeriod(now time.Time) (ChartParams, error) {
+	if p.Bucket == "" {
+		p.Bucket = ChartBucketDay
+	}
+	if p.Bucket != ChartBucketHour && p.Bucket != ChartBucketDay && p.Bucket != ChartBucketWeek && p.Bucket != ChartBucketMonth {
+		return p, fmt.Errorf("%w: bucket must be %v, %v, %v or %v", ErrInvalidChart, ChartBucketHour, ChartBucketDay, ChartBucketWeek, ChartBucketMonth)
+	}
+	if p.To.IsZero() {
+		p.To = now.In(chartLocation)
+	}
+	if p.From.IsZero() {
+		p.From = p.To.AddDate(0, 0, -(defaultChartDays - 1))
+	}
+	if p.To.Before(p.From) {
+		return p, fmt.Errorf("%w: to must not be before from", ErrInvalidChart)
+	}
+	if len(p.buckets()) > maxChartBuckets {
+		return p, fmt.Errorf("%w: the period has more than %v buckets, use a larger bucket", ErrInvalidChart, maxChartBuckets)
+	}
+	return p, nil
+}
+
+// truncate does the same as date_trunc in postgres, in chartTimezone
+func (p ChartParams) truncate(t time.Time) time.Time {
+	t = t.In(chartLocation)
+	switch p.Bucket {
+	case ChartBucketHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, chartLocation)
+	case ChartBucketWeek:
+		// weeks start on monday
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, chartLocation)
+	case ChartBucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, chartLocation)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, chartLocation)
+	}
+}
+
+func (p ChartParams) next(t time.Time) time.Time {
+	switch p.Bucket {
+	case ChartBucketHour:
+		return t.Add(time.Hour)
+	case ChartBucketWeek:
+		return t.AddDate(0, 0, 7)
+	case ChartBucketMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// label formats the wall clock time of a bucket
+func (p ChartParams) label(t time.Time) string {
+	switch p.Bucket {
+	case ChartBucketHour:
+		return t.Format("01-02 15:00")
+	case ChartBucketWeek:
+		return t.Format("2006-01-02")
+	case ChartBucketMonth:
+		return t.Format("2006-01")
+	default:
+		if p.From.Year() != p.To.Year() {
+			return t.Format("2006-01-02")
+		}
+		return t.Format("01-02")
+	}
+}
+
+// buckets returns the labels of the buckets in the period, in order
+func (p ChartParams) buckets() []string {
+	labels := make([]string, 0)
+	seen := make(map[string]bool)
+	end := p.end()
+	for t := p.truncate(p.start()); t.Before(end); t = p.next(t) {
+		label := p.label(t)
+		// an hour is repeated when daylight saving time ends
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+type ChartSeries struct {
+	Query  string
+	Labels []string
+	Data   []int
+	// SiteCounts is the number of matching items at each site in the period
+	SiteCounts []ChartCount
+}
+
+// GetChartSeries counts the items matching each query in each bucket of the period
+func (r *RssService) GetChartSeries(ctx context.Context, params ChartParams) ([]ChartSeries, error) {
+	params, err := params.validate(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	series := []ChartSeries{}
+	cacheKey := r.cacheKey(ctx, fmt.Sprintf("ChartSeries:%v:%v:%v:%v:%v", strings.Join(params.tsQueries, ","), params.start().Unix(), params.end().Unix(), params.Bucket, params.Unique))
+	if err := r.context.Cache.Get(ctx, cacheKey, &series); err == nil {
+		return series, nil
+	}
+	labels := params.buckets()
+	for i, tsQuery := range params.tsQueries {
+		bucketCounts, err := r.repository.CountItemsByBucket(tsQuery, params.Bucket, params.start(), params.end(), params.Unique)
+		if err != nil {
+			return nil, err
+		}
+		countByLabel := make(map[string]int)
+		for _, count := range bucketCounts {
+			// the bucket is the wall clock time in chartTimezone
+			countByLabel[params.label(count.Bucket)] += count.Count
+		}
+		data := make([]int, len(labels))
+		for j, label := range labels {
+			data[j] = countByLabel[label]
+		}
+		siteCounts, err := r.repository.CountItemsBySite(tsQuery, params.start(), params.end(), params.Unique)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, ChartSeries{
+			Query:      params.Queries[i],
+			Labels:     labels,
+			Data:       data,
+			SiteCounts: siteCounts,
+		})
+	}
+	r.context.Cache.Set(ctx, cacheKey, series, time.Hour)
+	return series, nil
+}
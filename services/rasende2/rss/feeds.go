@@ -0,0 +1,278 @@
+package rss
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	FeedFormatRss  = "rss"
+	FeedFormatAtom = "atom"
+	FeedFormatJson = "json"
+
+	// feedLimit is the number of items in a generated feed
+	feedLimit = 50
+	// feedMaxAge is how long feed readers and proxies may cache a generated feed
+	feedMaxAge = 15 * time.Minute
+)
+
+// SearchFeed is a feed of the newest items matching a search
+type SearchFeed struct {
+	Title       string
+	Description string
+	// Url is the url of the feed itself
+	Url     string
+	Items   []SearchHit
+	Updated time.Time
+	// Language is the language of the items, and empty if they have different languages
+	Language string
+}
+
+func NewSearchFeed(query string, url string, hits []SearchHit) SearchFeed {
+	feed := SearchFeed{
+		Title:       "Rasende2: " + query,
+		Description: "De nyeste artikler fra nyhedssider, der matcher '" + query + "'",
+		Url:         url,
+		Items:       hits,
+	}
+	for i, hit := range hits {
+		if hit.Published.After(feed.Updated) {
+			feed.Updated = hit.Published
+		}
+		if i == 0 {
+			feed.Language = hit.Language
+		} else if hit.Language != feed.Language {
+			feed.Language = ""
+		}
+	}
+	return feed
+}
+
+// ETag changes when the items in the feed change
+func (f SearchFeed) ETag(format string) string {
+	h := sha1.New()
+	h.Write([]byte(format + ":" + f.Url))
+	for _, item := range f.Items {
+		h.Write([]byte(":" + item.ItemId + ":" + item.Title))
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum(nil))
+}
+
+// itemGuid is a stable identifier of the item, since the link of an item can change
+func itemGuid(item RssItemDto) string {
+	return "urn:rasende2:item:" + item.ItemId
+}
+
+func feedItemTitle(item RssItemDto) string {
+	return item.SiteName + ": " + item.Title
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	Language      string      `xml:"language,omitempty"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Ttl           int         `xml:"ttl"`
+	AtomLink      rssAtomLink `xml:"atom:link"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssGuid struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	Description string  `xml:"description,omitempty"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Source      string  `xml:"category"`
+}
+
+func (f SearchFeed) Rss() ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Url,
+			Description: f.Description,
+			Language:    f.Language,
+			Ttl:         int(feedMaxAge.Minutes()),
+			AtomLink:    rssAtomLink{Href: f.Url, Rel: "self", Type: "application/rss+xml"},
+			Items:       make([]rssItem, 0, len(f.Items)),
+		},
+	}
+	if !f.Updated.IsZero() {
+		feed.Channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, item := range f.Items {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       feedItemTitle(item.RssItemDto),
+			Link:        item.Link,
+			Description: item.Content,
+			Guid:        rssGuid{IsPermaLink: "false", Value: itemGuid(item.RssItemDto)},
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+			Source:      item.SiteName,
+		})
+	}
+	return marshalXml(feed)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Id        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Links     []atomLink `xml:"link"`
+	Author    atomAuthor `xml:"author"`
+	Summary   *atomText  `xml:"summary,omitempty"`
+}
+
+func (f SearchFeed) Atom() ([]byte, error) {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed := atomFeed{
+		Id:      f.Url,
+		Title:   f.Title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Href: f.Url, Rel: "self"}},
+		Author:  atomAuthor{Name: "Rasende2"},
+		Entries: make([]atomEntry, 0, len(f.Items)),
+	}
+	for _, item := range f.Items {
+		entry := atomEntry{
+			Id:        itemGuid(item.RssItemDto),
+			Title:     feedItemTitle(item.RssItemDto),
+			Updated:   item.Published.UTC().Format(time.RFC3339),
+			Published: item.Published.UTC().Format(time.RFC3339),
+			Links:     []atomLink{},
+			Author:    atomAuthor{Name: item.SiteName},
+		}
+		if item.Link != "" {
+			entry.Links = append(entry.Links, atomLink{Href: item.Link, Rel: "alternate"})
+		}
+		if item.Content != "" {
+			entry.Summary = &atomText{Type: "text", Value: item.Content}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return marshalXml(feed)
+}
+
+func marshalXml(v any) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	FeedUrl     string         `json:"feed_url"`
+	Language    string         `json:"language,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	Id            string           `json:"id"`
+	Url           string           `json:"url,omitempty"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+	Tags          []string         `json:"tags"`
+}
+
+func (f SearchFeed) Json() ([]byte, error) {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		Description: f.Description,
+		FeedUrl:     f.Url,
+		Language:    f.Language,
+		Items:       make([]jsonFeedItem, 0, len(f.Items)),
+	}
+	for _, item := range f.Items {
+		feed.Items = append(feed.Items, jsonFeedItem{
+			Id:            itemGuid(item.RssItemDto),
+			Url:           item.Link,
+			Title:         feedItemTitle(item.RssItemDto),
+			ContentText:   item.Content,
+			DatePublished: item.Published.UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: item.SiteName}},
+			Tags:          []string{item.SiteName},
+		})
+	}
+	return json.Marshal(feed)
+}
+
+// Render returns the feed in the format, and its content type
+func (f SearchFeed) Render(format string) ([]byte, string, error) {
+	switch strings.ToLower(format) {
+	case FeedFormatAtom:
+		body, err := f.Atom()
+		return body, "application/atom+xml; charset=utf-8", err
+	case FeedFormatJson:
+		body, err := f.Json()
+		return body, "application/feed+json; charset=utf-8", err
+	case FeedFormatRss, "":
+		body, err := f.Rss()
+		return body, "application/rss+xml; charset=utf-8", err
+	default:
+		return nil, "", fmt.Errorf("%w: format must be %v, %v or %v", ErrInvalidSearch, FeedFormatRss, FeedFormatAtom, FeedFormatJson)
+	}
+}
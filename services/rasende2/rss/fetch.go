@@ -0,0 +1,75 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// fetchConcurrency is the number of sources fetched at the same time
+	fetchConcurrency = 8
+	fetchTimeout     = 20 * time.Second
+	// feeds larger than this are not feeds
+	maxFeedSize    = 20 * 1024 * 1024
+	fetchUserAgent = "rasende2 (+https://rasende2-api.bjarke.xyz)"
+	// feedCacheTTL is shorter than the shortest polling interval, so feeds are always revalidated when they are polled
+	feedCacheTTL = time.Minute
+)
+
+// FetchValidators are the cache validators returned the last time a feed url was fetched,
+// used to make conditional requests
+type FetchValidators struct {
+	Url          string    `db:"url"`
+	ETag         string    `db:"etag"`
+	LastModified string    `db:"last_modified"`
+	UpdatedAt    time.Time `db:"updated_at"`
+}
+
+type feedContent struct {
+	body       string
+	validators FetchValidators
+}
+
+// fetchFeed gets the feed at the url. If validators are given, a conditional request is made,
+// and nil is returned if the feed has not been modified.
+func (r *RssService) fetchFeed(url string, validators *FetchValidators) (*feedContent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/feed+json, application/xml;q=0.9, text/xml;q=0.9, */*;q=0.8")
+	if validators != nil {
+		if validators.ETag != "" {
+			req.Header.Set("If-None-Match", validators.ETag)
+		}
+		if validators.LastModified != "" {
+			req.Header.Set("If-Modified-Since", validators.LastModified)
+		}
+	}
+
+	// requests go through the http cache if one is configured, and otherwise directly to the server
+	entry, err := r.httpCache.Fetch(r.httpClient, req, feedCacheTTL, maxFeedSize)
+	if err != nil {
+		return nil, err
+	}
+	if entry.NotModified {
+		return nil, nil
+	}
+	if entry.StatusCode > 299 {
+		return nil, fmt.Errorf("error getting %v, returned error code %v", url, entry.StatusCode)
+	}
+	return &feedContent{
+		body: string(entry.Body),
+		validators: FetchValidators{
+			Url:          url,
+			ETag:         entry.Header.Get("ETag"),
+			LastModified: entry.Header.Get("Last-Modified"),
+			UpdatedAt:    entry.FetchedAt,
+		},
+	}, nil
+}
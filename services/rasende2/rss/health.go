@@ -0,0 +1,95 @@
+package rss
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// maxBackoff is the longest time to wait before retrying a failing source
+	maxBackoff = 24 * time.Hour
+	// autoDisableAfterFailures is the number of consecutive failures after which a source is disabled
+	autoDisableAfterFailures = 10
+)
+
+type SourceHealth struct {
+	SourceId            int        `db:"source_id" json:"sourceId"`
+	Name                string     `db:"name" json:"name"`
+	Enabled             bool       `db:"enabled" json:"enabled"`
+	LastSuccess         *time.Time `db:"last_success" json:"lastSuccess"`
+	LastFailure         *time.Time `db:"last_failure" json:"lastFailure"`
+	ConsecutiveFailures int        `db:"consecutive_failures" json:"consecutiveFailures"`
+	LastError           string     `db:"last_error" json:"lastError"`
+	// LastItemCount is the number of items in the feed at the last successful fetch, 0 if the feed was not modified
+	LastItemCount int `db:"last_item_count" json:"lastItemCount"`
+	// LastNewItemCount is the number of new items at the last successful fetch
+	LastNewItemCount int        `db:"last_new_item_count" json:"lastNewItemCount"`
+	LastNewItemsAt   *time.Time `db:"last_new_items_at" json:"lastNewItemsAt"`
+	NextAttempt      *time.Time `db:"next_attempt" json:"nextAttempt"`
+	AutoDisabled     bool       `db:"auto_disabled" json:"autoDisabled"`
+}
+
+// isBackingOff returns true if the source failed recently, and should not be retried yet
+func (h *SourceHealth) isBackingOff(now time.Time) bool {
+	return h.NextAttempt != nil && h.NextAttempt.After(now)
+}
+
+// backoff doubles the polling interval for each consecutive failure
+func backoff(pollingInterval time.Duration, consecutiveFailures int) time.Duration {
+	delay := pollingInterval
+	for i := 1; i < consecutiveFailures && delay < maxBackoff; i++ {
+		delay = delay * 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+type fetchResult struct {
+	itemCount        int
+	newItemCount     int
+	changedItemCount int
+}
+
+func (r *RssService) recordHealth(source RssSource, health *SourceHealth, result fetchResult, fetchErr error, now time.Time) {
+	if health == nil {
+		health = &SourceHealth{SourceId: source.Id}
+	}
+	if fetchErr == nil {
+		health.LastSuccess = &now
+		health.ConsecutiveFailures = 0
+		health.LastError = ""
+		health.LastItemCount = result.itemCount
+		health.LastNewItemCount = result.newItemCount
+		if result.newItemCount > 0 {
+			health.LastNewItemsAt = &now
+		}
+		health.NextAttempt = nil
+		health.AutoDisabled = false
+	} else {
+		health.LastFailure = &now
+		health.ConsecutiveFailures++
+		health.LastError = fetchErr.Error()
+		pollingInterval := time.Duration(source.PollingIntervalMinutes) * time.Minute
+		nextAttempt := now.Add(backoff(pollingInterval, health.ConsecutiveFailures))
+		health.NextAttempt = &nextAttempt
+		if health.ConsecutiveFailures >= autoDisableAfterFailures && !health.AutoDisabled {
+			log.Printf("source %v failed %v times in a row, disabling it: %v", source.Name, health.ConsecutiveFailures, fetchErr)
+			err := r.repository.SetSourceEnabled(source.Id, false)
+			if err != nil {
+				log.Printf("failed to disable source %v: %v", source.Name, err)
+			} else {
+				health.AutoDisabled = true
+			}
+		}
+	}
+	err := r.repository.SaveSourceHealth(*health)
+	if err != nil {
+		log.Printf("failed to save health of source %v: %v", source.Name, err)
+	}
+}
+
+func (r *RssService) GetSourcesHealth() ([]SourceHealth, error) {
+	return r.repository.GetSourcesHealth()
+}
@@ -1,31 +1,75 @@
 package rss
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"sort"
 	"strconv"
 	"time"
 
 	"github.com/bjarke-xyz/rasende2/pkg"
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 type HttpHandlers struct {
 	context *pkg.AppContext
 	service *RssService
+	stream  *ItemStream
 }
 
-func NewHttpHandlers(context *pkg.AppContext, service *RssService) *HttpHandlers {
+func NewHttpHandlers(context *pkg.AppContext, service *RssService, stream *ItemStream) *HttpHandlers {
 	return &HttpHandlers{
 		context: context,
 		service: service,
+		stream:  stream,
 	}
 }
 
 type SearchResult struct {
-	HighlightedWords []string     `json:"highlightedWords"`
-	Items            []RssItemDto `json:"items"`
+	HighlightedWords []string    `json:"highlightedWords"`
+	Items            []SearchHit `json:"items"`
+	Total            int         `json:"total"`
+	NextCursor       string      `json:"nextCursor"`
+}
+
+func NewSearchResult(page *SearchPage) SearchResult {
+	return SearchResult{
+		HighlightedWords: highlightedWords(page.Hits),
+		Items:            page.Hits,
+		Total:            page.Total,
+		NextCursor:       page.NextCursor,
+	}
+}
+
+// parseSearchDate parses a date in chartTimezone, so a search for a day finds the items counted for that day in the charts
+func parseSearchDate(dateStr string) (time.Time, error) {
+	if dateStr == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", dateStr, chartLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: dates must be formatted as 2006-01-02", ErrInvalidSearch)
+	}
+	return date, nil
+}
+
+// ParseSearchPeriod parses the from and to dates of a search. Both are optional, and to is inclusive.
+func ParseSearchPeriod(fromStr string, toStr string) (time.Time, time.Time, error) {
+	from, err := parseSearchDate(fromStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parseSearchDate(toStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !to.IsZero() {
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
 }
 
 func (h *HttpHandlers) HandleSearch(c *gin.Context) {
@@ -40,24 +84,120 @@ func (h *HttpHandlers) HandleSearch(c *gin.Context) {
 	if err != nil {
 		searchContent = false
 	}
-	results, err := h.service.SearchItems(c.Request.Context(), query, searchContent)
+	from, to, err := ParseSearchPeriod(c.Query("from"), c.Query("to"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := h.service.Search(c.Request.Context(), SearchParams{
+		Query:         query,
+		SearchContent: searchContent,
+		Sites:         c.QueryArray("site"),
+		Languages:     c.QueryArray("lang"),
+		From:          from,
+		To:            to,
+		Sort:          c.DefaultQuery("sort", SearchSortRecency),
+		Limit:         limit,
+		Cursor:        c.Query("cursor"),
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidSearch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Printf("failed to get items with query %v: %v", query, err)
 		c.JSON(http.StatusInternalServerError, SearchResult{})
 		return
 	}
-	if len(results) > limit {
-		results = results[0:limit]
+	c.JSON(http.StatusOK, NewSearchResult(page))
+}
+
+func (h *HttpHandlers) HandleSearchFeed(c *gin.Context) {
+	query := c.Query("q")
+	searchContent, err := strconv.ParseBool(c.DefaultQuery("content", "false"))
+	if err != nil {
+		searchContent = false
 	}
-	c.JSON(http.StatusOK, SearchResult{
-		HighlightedWords: []string{query},
-		Items:            results,
+	format := c.DefaultQuery("format", FeedFormatRss)
+	page, err := h.service.Search(c.Request.Context(), SearchParams{
+		Query:         query,
+		SearchContent: searchContent,
+		Sites:         c.QueryArray("site"),
+		Languages:     c.QueryArray("lang"),
+		Sort:          SearchSortRecency,
+		Limit:         feedLimit,
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidSearch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to get feed with query %v: %v", query, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	feed := NewSearchFeed(query, scheme+"://"+c.Request.Host+c.Request.URL.RequestURI(), page.Hits)
+	body, contentType, err := feed.Render(format)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSearch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to render %v feed with query %v: %v", format, query, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	etag := feed.ETag(format)
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%v", int(feedMaxAge.Seconds())))
+	// There is no Last-Modified, since the newest published date does not change when an older item is added late,
+	// so only the ETag tells whether the feed changed
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// HandleStream streams the new items matching q as server-sent events, until the client disconnects
+func (h *HttpHandlers) HandleStream(c *gin.Context) {
+	query := c.Query("q")
+	searchContent, err := strconv.ParseBool(c.DefaultQuery("content", "false"))
+	if err != nil {
+		searchContent = false
+	}
+	items, unsubscribe, err := h.stream.Subscribe(query, searchContent)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer unsubscribe()
+	c.Header("Cache-Control", "no-cache")
+	// disables buffering in nginx, so events are sent right away
+	c.Header("X-Accel-Buffering", "no")
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case item := <-items:
+			c.SSEvent("item", item)
+		case <-keepAlive.C:
+			c.SSEvent("ping", time.Now().Unix())
+		}
+		return true
 	})
 }
 
 type ChartDataset struct {
-	Label string `json:"label"`
-	Data  []int  `json:"data"`
+	Label string    `json:"label"`
+	Data  []float64 `json:"data"`
 }
 
 type ChartResult struct {
@@ -71,68 +211,34 @@ type ChartsResult struct {
 	Charts []ChartResult `json:"charts"`
 }
 
-func MakeLineChart(items []RssItemDto, title string, datasetLabel string) ChartResult {
-	dateFormat := "01-02"
-	today := time.Now()
-	sevenDaysAgo := today.Add(-time.Hour * 24 * 6)
-	lastWeekItemsGroupedByDate := make(map[string]int)
-	for _, item := range items {
-		if item.Published.Before(today) && item.Published.After(sevenDaysAgo) {
-			key := item.Published.Format(dateFormat)
-			_, ok := lastWeekItemsGroupedByDate[key]
-			if !ok {
-				lastWeekItemsGroupedByDate[key] = 0
-			}
-			lastWeekItemsGroupedByDate[key] = lastWeekItemsGroupedByDate[key] + 1
-		}
-	}
+func MakeLineChart(series []ChartSeries, title string, datasetLabels []string) ChartResult {
 	labels := make([]string, 0)
-	data := make([]int, 0)
-	for d := sevenDaysAgo; !d.After(today); d = d.AddDate(0, 0, 1) {
-		labels = append(labels, d.Format(dateFormat))
-		datum, ok := lastWeekItemsGroupedByDate[d.Format(dateFormat)]
-		if ok {
-			data = append(data, datum)
-		} else {
-			data = append(data, 0)
+	datasets := make([]ChartDataset, 0, len(series))
+	for i, s := range series {
+		labels = s.Labels
+		data := make([]float64, len(s.Data))
+		for j, datum := range s.Data {
+			data[j] = float64(datum)
 		}
+		datasets = append(datasets, ChartDataset{
+			Label: datasetLabels[i],
+			Data:  data,
+		})
 	}
-
 	return ChartResult{
-		Type:   "line",
-		Title:  title,
-		Labels: labels,
-		Datasets: []ChartDataset{
-			{
-				Label: datasetLabel,
-				Data:  data,
-			},
-		},
+		Type:     "line",
+		Title:    title,
+		Labels:   labels,
+		Datasets: datasets,
 	}
 }
 
-func MakeDoughnutChart(items []RssItemDto, title string) ChartResult {
-	sitesSet := make(map[string][]RssItemDto)
-	for _, item := range items {
-		_, ok := sitesSet[item.SiteName]
-		if !ok {
-			sitesSet[item.SiteName] = make([]RssItemDto, 0)
-		}
-		sitesSet[item.SiteName] = append(sitesSet[item.SiteName], item)
-
-	}
-
-	labels := make([]string, 0)
-	data := make([]int, 0)
-	for siteName := range sitesSet {
-		labels = append(labels, siteName)
-	}
-	sort.Strings(labels)
-	for _, siteName := range labels {
-		siteItems, ok := sitesSet[siteName]
-		if ok {
-			data = append(data, len(siteItems))
-		}
+func MakeDoughnutChart(siteCounts []ChartCount, title string) ChartResult {
+	labels := make([]string, 0, len(siteCounts))
+	data := make([]float64, 0, len(siteCounts))
+	for _, siteCount := range siteCounts {
+		labels = append(labels, siteCount.SiteName)
+		data = append(data, float64(siteCount.Count))
 	}
 
 	return ChartResult{
@@ -148,30 +254,202 @@ func MakeDoughnutChart(items []RssItemDto, title string) ChartResult {
 	}
 }
 
+// parseChartDate parses a date in chartTimezone
+func parseChartDate(dateStr string) (time.Time, error) {
+	if dateStr == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", dateStr, chartLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: dates must be formatted as 2006-01-02", ErrInvalidChart)
+	}
+	return date, nil
+}
+
+// ParseChartPeriod parses the optional from and to dates of a chart, in chartTimezone
+func ParseChartPeriod(fromStr string, toStr string) (time.Time, time.Time, error) {
+	from, err := parseChartDate(fromStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parseChartDate(toStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, nil
+}
+
 func (h *HttpHandlers) HandleCharts(c *gin.Context) {
-	query := c.Query("q")
-	results, err := h.service.SearchItems(c.Request.Context(), query, false)
+	queries := c.QueryArray("q")
+	unique, err := strconv.ParseBool(c.DefaultQuery("unique", "false"))
 	if err != nil {
-		log.Printf("failed to get items with query %v: %v", query, err)
+		unique = false
+	}
+	from, to, err := ParseChartPeriod(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	series, err := h.service.GetChartSeries(c.Request.Context(), ChartParams{
+		Queries: queries,
+		From:    from,
+		To:      to,
+		Bucket:  c.DefaultQuery("bucket", ChartBucketDay),
+		Unique:  unique,
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidSearch) || errors.Is(err, ErrInvalidChart) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to get charts with queries %v: %v", queries, err)
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
-	lineTitle := "Den seneste uges raserier"
-	lineDatasetLabel := "Raseriudbrud"
-	doughnutTitle := "Raseri i de forskellige medier"
-	if query != "rasende" {
-		lineTitle = "Den seneste uges brug af '" + query + "'"
-		lineDatasetLabel = "Antal '" + query + "'"
-		doughnutTitle = "Brug af '" + query + "' i de forskellige medier"
+	isDefaultPeriod := from.IsZero() && to.IsZero() && c.DefaultQuery("bucket", ChartBucketDay) == ChartBucketDay
+	lineTitle := "Brug over tid"
+	if isDefaultPeriod {
+		lineTitle = "Den seneste uges brug"
+	}
+	datasetLabels := make([]string, len(series))
+	charts := make([]ChartResult, 0, len(series)+1)
+	doughnuts := make([]ChartResult, 0, len(series))
+	for i, s := range series {
+		datasetLabels[i] = "Antal '" + s.Query + "'"
+		doughnutTitle := "Brug af '" + s.Query + "' i de forskellige medier"
+		if s.Query == defaultChartQuery {
+			datasetLabels[i] = "Raseriudbrud"
+			doughnutTitle = "Raseri i de forskellige medier"
+		}
+		doughnuts = append(doughnuts, MakeDoughnutChart(s.SiteCounts, doughnutTitle))
+	}
+	if len(series) == 1 {
+		if series[0].Query == defaultChartQuery {
+			lineTitle = "Raserier over tid"
+			if isDefaultPeriod {
+				lineTitle = "Den seneste uges raserier"
+			}
+		} else {
+			lineTitle = lineTitle + " af '" + series[0].Query + "'"
+		}
+	}
+	charts = append(charts, MakeLineChart(series, lineTitle, datasetLabels))
+	charts = append(charts, doughnuts...)
+	c.JSON(http.StatusOK, ChartsResult{
+		Charts: charts,
+	})
+}
+
+func (h *HttpHandlers) HandleAngerCharts(c *gin.Context) {
+	from, to, err := ParseChartPeriod(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	series, err := h.service.GetScoreSeries(c.Request.Context(), ScoreChartParams{
+		From:   from,
+		To:     to,
+		Bucket: c.DefaultQuery("bucket", ChartBucketDay),
+		Sites:  c.QueryArray("site"),
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidChart) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to get anger charts: %v", err)
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+	siteLabels := make([]string, 0, len(series.Sites))
+	siteAnger := make([]float64, 0, len(series.Sites))
+	for _, site := range series.Sites {
+		siteLabels = append(siteLabels, site.SiteName)
+		siteAnger = append(siteAnger, site.Anger)
 	}
 	c.JSON(http.StatusOK, ChartsResult{
 		Charts: []ChartResult{
-			MakeLineChart(results, lineTitle, lineDatasetLabel),
-			MakeDoughnutChart(results, doughnutTitle),
+			{
+				Type:   "line",
+				Title:  "Gennemsnitligt raseri over tid",
+				Labels: series.Labels,
+				Datasets: []ChartDataset{
+					{Label: "Raseri", Data: series.Anger},
+					{Label: "Negativitet", Data: series.Negativity},
+				},
+			},
+			{
+				Type:   "bar",
+				Title:  "Gennemsnitligt raseri i de forskellige medier",
+				Labels: siteLabels,
+				Datasets: []ChartDataset{
+					{Label: "Raseri", Data: siteAnger},
+				},
+			},
 		},
 	})
 }
 
+// HandleCacheStats returns the cache hits and misses of this instance, by the name of the cached result
+func (h *HttpHandlers) HandleCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.context.Cache.Stats())
+}
+
+// queryInt returns the query parameter as an int, or 0 if it is missing or not a number
+func queryInt(c *gin.Context, key string) int {
+	value, err := strconv.Atoi(c.Query(key))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func analyticsError(c *gin.Context, err error) {
+	if errors.Is(err, ErrInvalidSearch) || errors.Is(err, ErrInvalidAnalytics) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("failed to get analytics for %v: %v", c.Request.URL, err)
+	c.JSON(http.StatusInternalServerError, nil)
+}
+
+func (h *HttpHandlers) HandleTrending(c *gin.Context) {
+	searchContent, err := strconv.ParseBool(c.DefaultQuery("content", "false"))
+	if err != nil {
+		searchContent = false
+	}
+	terms, err := h.service.GetTrendingTerms(c.Request.Context(), TrendingParams{
+		Days:          queryInt(c, "days"),
+		BaselineDays:  queryInt(c, "baseline"),
+		Site:          c.Query("site"),
+		SearchContent: searchContent,
+		Limit:         queryInt(c, "limit"),
+	})
+	if err != nil {
+		analyticsError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, terms)
+}
+
+func (h *HttpHandlers) HandleRageLeaderboard(c *gin.Context) {
+	leaderboard, err := h.service.GetRageLeaderboard(c.Request.Context(), queryInt(c, "weeks"))
+	if err != nil {
+		analyticsError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, leaderboard)
+}
+
+func (h *HttpHandlers) HandleCooccurring(c *gin.Context) {
+	terms, err := h.service.GetCooccurringTerms(c.Request.Context(), c.Query("q"), queryInt(c, "days"), queryInt(c, "limit"))
+	if err != nil {
+		analyticsError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, terms)
+}
+
 func (h *HttpHandlers) RunJob(key string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.GetHeader("Authorization") != key {
@@ -182,3 +460,139 @@ func (h *HttpHandlers) RunJob(key string) gin.HandlerFunc {
 		c.Status(http.StatusOK)
 	}
 }
+
+// RequireKey aborts requests that do not have the key in the Authorization header
+func (h *HttpHandlers) RequireKey(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != key {
+			c.AbortWithStatus(401)
+			return
+		}
+		c.Next()
+	}
+}
+
+func sourceErrorStatus(err error) int {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, ErrInvalidSource):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrSourceNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &pqErr) && pqErr.Code == "23505":
+		// unique_violation, a source with the name already exists
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *HttpHandlers) sourceError(c *gin.Context, err error) {
+	status := sourceErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("source request failed: %v", err)
+		c.JSON(status, gin.H{"error": "internal error"})
+		return
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+func parseSourceId(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *HttpHandlers) HandleGetSources(c *gin.Context) {
+	sources, err := h.service.GetSources()
+	if err != nil {
+		h.sourceError(c, err)
+		return
+	}
+	if proposed, err := strconv.ParseBool(c.Query("proposed")); err == nil {
+		filtered := make([]RssSource, 0, len(sources))
+		for _, source := range sources {
+			if source.Proposed == proposed {
+				filtered = append(filtered, source)
+			}
+		}
+		sources = filtered
+	}
+	c.JSON(http.StatusOK, sources)
+}
+
+func (h *HttpHandlers) HandleGetSource(c *gin.Context) {
+	id, ok := parseSourceId(c)
+	if !ok {
+		return
+	}
+	source, err := h.service.GetSource(id)
+	if err != nil {
+		h.sourceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, source)
+}
+
+func (h *HttpHandlers) HandleCreateSource(c *gin.Context) {
+	source := RssSource{Enabled: true}
+	if err := c.ShouldBindJSON(&source); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	created, err := h.service.CreateSource(source)
+	if err != nil {
+		h.sourceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *HttpHandlers) HandleUpdateSource(c *gin.Context) {
+	id, ok := parseSourceId(c)
+	if !ok {
+		return
+	}
+	// Start from the existing source, so fields left out of the body are kept
+	source, err := h.service.GetSource(id)
+	if err != nil {
+		h.sourceError(c, err)
+		return
+	}
+	if err := c.ShouldBindJSON(source); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	source.Id = id
+	updated, err := h.service.UpdateSource(*source)
+	if err != nil {
+		h.sourceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *HttpHandlers) HandleDeleteSource(c *gin.Context) {
+	id, ok := parseSourceId(c)
+	if !ok {
+		return
+	}
+	err := h.service.DeleteSource(id)
+	if err != nil {
+		h.sourceError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *HttpHandlers) HandleGetSourcesHealth(c *gin.Context) {
+	health, err := h.service.GetSourcesHealth()
+	if err != nil {
+		h.sourceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, health)
+}
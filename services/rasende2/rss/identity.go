@@ -0,0 +1,279 @@
+package rss
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	minHashSize = 64
+	// shingleSize is the number of characters in each shingle
+	shingleSize = 5
+	// only the start of the content is used, the rest is often boilerplate
+	maxShingleContentLength = 500
+	// nearDuplicateThreshold is the estimated jaccard similarity above which two items are the same story
+	nearDuplicateThreshold = 0.6
+	// nearDuplicateWindow is how far back to look for near duplicates of new items
+	nearDuplicateWindow = 72 * time.Hour
+	// canonicalLinkBatchSize is the number of items given a canonical link at a time by BackfillCanonicalLinks
+	canonicalLinkBatchSize = 5000
+)
+
+// trackingParams are removed from links, since the same article is linked with different values
+var trackingParams = []string{"utm_", "fbclid", "gclid", "xtor"}
+
+// canonicalLink normalizes a link, so different links to the same article are equal
+func canonicalLink(link string) string {
+	link = strings.TrimSpace(link)
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+	u.Host = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	u.Fragment = ""
+	query := u.Query()
+	for key := range query {
+		lowerKey := strings.ToLower(key)
+		for _, param := range trackingParams {
+			if lowerKey == param || (strings.HasSuffix(param, "_") && strings.HasPrefix(lowerKey, param)) {
+				query.Del(key)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	if u.Path != "/" {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+	}
+	return u.String()
+}
+
+// BackfillCanonicalLinks computes the canonical links of items stored before items had them, so they are recognized
+// when they are seen again. It is run before each ingestion, and does nothing when all items have a canonical link.
+func (r *RssService) BackfillCanonicalLinks() (int, error) {
+	updated := 0
+	afterItemId := ""
+	for {
+		items, err := r.repository.GetItemsWithoutCanonicalLink(afterItemId, canonicalLinkBatchSize)
+		if err != nil {
+			return updated, err
+		}
+		if len(items) == 0 {
+			break
+		}
+		for i := range items {
+			items[i].CanonicalLink = canonicalLink(items[i].Link)
+		}
+		err = r.repository.SetCanonicalLinks(items)
+		if err != nil {
+			return updated, err
+		}
+		updated += len(items)
+		afterItemId = items[len(items)-1].ItemId
+	}
+	if updated > 0 {
+		log.Printf("BackfillCanonicalLinks: computed the canonical links of %v items", updated)
+	}
+	return updated, nil
+}
+
+// getItemId identifies an item by its guid, or by its canonical link if it has no guid,
+// so a changed headline does not make a new item
+func getItemId(siteName string, item *gofeed.Item) string {
+	str := item.Title + ":" + item.Link
+	guid := strings.TrimSpace(item.GUID)
+	link := canonicalLink(item.Link)
+	if guid != "" {
+		str = siteName + ":guid:" + guid
+	} else if link != "" {
+		str = siteName + ":link:" + link
+	}
+	bytes := []byte(str)
+	hashedBytes := md5.Sum(bytes)
+	hashStr := fmt.Sprintf("%x", hashedBytes)
+	return hashStr
+}
+
+func normalizeText(text string) string {
+	var builder strings.Builder
+	lastWasSpace := true
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+			lastWasSpace = false
+		} else if !lastWasSpace {
+			builder.WriteRune(' ')
+			lastWasSpace = true
+		}
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+func shingles(text string) map[string]bool {
+	runes := []rune(normalizeText(text))
+	result := make(map[string]bool)
+	if len(runes) < shingleSize {
+		if len(runes) > 0 {
+			result[string(runes)] = true
+		}
+		return result
+	}
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		result[string(runes[i:i+shingleSize])] = true
+	}
+	return result
+}
+
+// minHash calculates a MinHash signature of the title and the start of the content
+func minHash(title string, content string) []int64 {
+	contentRunes := []rune(content)
+	if len(contentRunes) > maxShingleContentLength {
+		contentRunes = contentRunes[:maxShingleContentLength]
+	}
+	itemShingles := shingles(title + " " + string(contentRunes))
+	if len(itemShingles) == 0 {
+		return nil
+	}
+	signature := make([]int64, minHashSize)
+	for i := range signature {
+		signature[i] = -1
+	}
+	for shingle := range itemShingles {
+		h := fnv.New64a()
+		h.Write([]byte(shingle))
+		base := h.Sum64()
+		for i := range signature {
+			// Each position uses a different hash function, derived from the base hash
+			value := int64((base ^ (uint64(i+1) * 0x9e3779b97f4a7c15)) * 0xbf58476d1ce4e5b9 >> 1)
+			if signature[i] == -1 || value < signature[i] {
+				signature[i] = value
+			}
+		}
+	}
+	return signature
+}
+
+// similarity estimates the jaccard similarity of the items the signatures were calculated from
+func similarity(a []int64, b []int64) float64 {
+	if len(a) != minHashSize || len(b) != minHashSize {
+		return 0
+	}
+	equal := 0
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(minHashSize)
+}
+
+// updateExistingItems returns the items that are new, and the number of changed items. Existing items, matched by id, guid
+// or canonical link, get their title updated if it has changed, and the previous title is stored as a revision.
+func (r *RssService) updateExistingItems(source RssSource, items []RssItemDto, now time.Time) ([]RssItemDto, int, error) {
+	if len(items) == 0 {
+		return items, 0, nil
+	}
+	ids := make([]string, 0, len(items))
+	guids := make([]string, 0, len(items))
+	links := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ItemId)
+		if item.Guid != "" {
+			guids = append(guids, item.Guid)
+		}
+		if item.CanonicalLink != "" {
+			links = append(links, item.CanonicalLink)
+		}
+	}
+	existing, err := r.repository.GetExistingItems(source.Name, ids, guids, links)
+	if err != nil {
+		return nil, 0, err
+	}
+	byId := make(map[string]*RssItemDto)
+	byGuid := make(map[string]*RssItemDto)
+	byLink := make(map[string]*RssItemDto)
+	for i := range existing {
+		item := &existing[i]
+		byId[item.ItemId] = item
+		if item.Guid != "" {
+			byGuid[item.Guid] = item
+		}
+		if item.CanonicalLink != "" {
+			byLink[item.CanonicalLink] = item
+		}
+	}
+
+	newItems := make([]RssItemDto, 0)
+	changedItems := make([]RssItemDto, 0)
+	revisions := make([]RssItemRevision, 0)
+	for _, item := range items {
+		match, ok := byId[item.ItemId]
+		if !ok && item.Guid != "" {
+			match, ok = byGuid[item.Guid]
+		}
+		if !ok && item.CanonicalLink != "" {
+			match, ok = byLink[item.CanonicalLink]
+		}
+		if !ok {
+			newItems = append(newItems, item)
+			continue
+		}
+		if match.Title == item.Title {
+			continue
+		}
+		revisions = append(revisions, RssItemRevision{
+			ItemId:    match.ItemId,
+			Title:     match.Title,
+			Link:      match.Link,
+			ChangedAt: now,
+		})
+		changed := item
+		changed.ItemId = match.ItemId
+		changedItems = append(changedItems, changed)
+		match.Title = item.Title
+		match.Link = item.Link
+	}
+	if len(changedItems) > 0 {
+		log.Printf("FetchAndSaveNewItems: %v changed the title of %v items", source.Name, len(changedItems))
+		err = r.repository.UpdateItemTitles(changedItems, revisions)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return newItems, len(changedItems), nil
+}
+
+// assignClusters gives each new item the cluster of the most similar recent item,
+// if it is a near duplicate. Otherwise the item starts its own cluster.
+func (r *RssService) assignClusters(items []RssItemDto, now time.Time) error {
+	if len(items) == 0 {
+		return nil
+	}
+	candidates, err := r.repository.GetRecentSignatures(now.Add(-nearDuplicateWindow))
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		best := nearDuplicateThreshold
+		for _, candidate := range candidates {
+			s := similarity(items[i].MinHash, candidate.MinHash)
+			if s >= best {
+				best = s
+				items[i].ClusterId = candidate.ClusterId
+			}
+		}
+		candidates = append(candidates, items[i])
+	}
+	return nil
+}
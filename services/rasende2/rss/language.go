@@ -0,0 +1,98 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	LanguageDanish    = "da"
+	LanguageSwedish   = "sv"
+	LanguageNorwegian = "no"
+	LanguageEnglish   = "en"
+
+	defaultLanguage = LanguageDanish
+
+	// itemLanguageBatchSize is the number of items given the language of their source at a time by UpdateItemLanguages
+	itemLanguageBatchSize = 5000
+)
+
+// Languages are the languages of sources. The text search configuration of each language is chosen by
+// the rss_search_config function in the database.
+var Languages = []string{LanguageDanish, LanguageSwedish, LanguageNorwegian, LanguageEnglish}
+
+func isLanguage(language string) bool {
+	for _, l := range Languages {
+		if l == language {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLanguages lowercases the languages, and returns an error if one is not supported
+func validateLanguages(languages []string) ([]string, error) {
+	validated := make([]string, 0, len(languages))
+	for _, language := range languages {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if !isLanguage(language) {
+			return nil, fmt.Errorf("language must be one of %v", strings.Join(Languages, ", "))
+		}
+		validated = append(validated, language)
+	}
+	return validated, nil
+}
+
+// tsMatchExpression is the sql matching the search vector column against the compiled query in param, for items in
+// one of the languages, or in any language if none are given. Each item is only matched against the query in its own
+// text search configuration, so negations and stems of other languages do not match it. The configuration of each
+// branch is a constant, so the branches use the index of the search vector.
+func tsMatchExpression(vector string, param string, languages []string) string {
+	if len(languages) == 0 {
+		languages = Languages
+	}
+	branches := make([]string, len(languages))
+	for i, language := range languages {
+		branches[i] = "(language = '" + language + "' AND " + vector + " @@ to_tsquery(rss_search_config('" + language + "'), " + param + "))"
+	}
+	return "(" + strings.Join(branches, " OR ") + ")"
+}
+
+// tsItemQuery is the sql of the compiled query in param, in the text search configuration of the language in
+// languageColumn. It is used to rank and highlight items matched by tsMatchExpression.
+func tsItemQuery(languageColumn string, param string) string {
+	return "to_tsquery(rss_search_config(" + languageColumn + "), " + param + ")"
+}
+
+// tsVectorExpression is the sql of the text in param as a search vector in the configurations of all languages
+func tsVectorExpression(param string) string {
+	vectors := make([]string, len(Languages))
+	for i, language := range Languages {
+		vectors[i] = "to_tsvector(rss_search_config('" + language + "'), " + param + ")"
+	}
+	return "(" + strings.Join(vectors, " || ") + ")"
+}
+
+// UpdateItemLanguages sets the language of the items to the language of their source, in batches, which rebuilds
+// their search vectors. Only items with another language than their source are updated, so it is cheap to call
+// when nothing has changed, and an update that failed halfway is finished by the next call.
+func (r *RssService) UpdateItemLanguages() (int, error) {
+	updated := 0
+	for {
+		count, err := r.repository.UpdateItemLanguages(itemLanguageBatchSize)
+		if err != nil {
+			return updated, err
+		}
+		updated += count
+		if count < itemLanguageBatchSize {
+			break
+		}
+	}
+	if updated > 0 {
+		log.Printf("UpdateItemLanguages: updated the language of %v items", updated)
+		r.invalidateCache(context.Background())
+	}
+	return updated, nil
+}
@@ -0,0 +1,156 @@
+package rss
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// maxQueryTerms limits the size of the compiled tsquery
+const maxQueryTerms = 10
+
+// queryTerm is a word, prefix or phrase in a search query
+type queryTerm struct {
+	lexemes []string
+	prefix  []bool
+	negated bool
+}
+
+func (t queryTerm) tsQuery() string {
+	parts := make([]string, len(t.lexemes))
+	for i, lexeme := range t.lexemes {
+		// lexemes only contain letters and digits, so quoting them is safe
+		parts[i] = "'" + lexeme + "'"
+		if t.prefix[i] {
+			parts[i] = parts[i] + ":*"
+		}
+	}
+	query := strings.Join(parts, " <-> ")
+	if len(parts) > 1 {
+		query = "(" + query + ")"
+	}
+	if t.negated {
+		query = "!" + query
+	}
+	return query
+}
+
+// addWords splits text into words of letters and digits. A word ending in * is a prefix.
+// Other characters separate words, so "covid-19" becomes the phrase covid 19.
+func (t *queryTerm) addWords(text string) {
+	var builder strings.Builder
+	flush := func(prefix bool) {
+		if builder.Len() > 0 {
+			t.lexemes = append(t.lexemes, builder.String())
+			t.prefix = append(t.prefix, prefix)
+			builder.Reset()
+		}
+	}
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+		} else {
+			flush(r == '*')
+		}
+	}
+	flush(false)
+}
+
+// parseSearchQuery compiles a search query into a tsquery, that can be given to to_tsquery without errors.
+//
+// Words must all match. "Quoted words" must match as a phrase, a word ending in * matches words starting with it,
+// a word or phrase starting with - must not match, and OR (or |) between words matches either of them.
+// OR binds tighter than the implicit AND, so `rasende politiker OR minister` is rasende & (politiker | minister).
+func parseSearchQuery(query string) (string, error) {
+	groups := make([][]queryTerm, 0)
+	pendingOr := false
+	hasPositive := false
+	termCount := 0
+
+	addTerm := func(term queryTerm) error {
+		if len(term.lexemes) == 0 {
+			if pendingOr {
+				return fmt.Errorf("%w: OR must be between two words", ErrInvalidSearch)
+			}
+			return nil
+		}
+		termCount++
+		if termCount > maxQueryTerms {
+			return fmt.Errorf("%w: query must have at most %v words or phrases", ErrInvalidSearch, maxQueryTerms)
+		}
+		if !term.negated {
+			hasPositive = true
+		}
+		if pendingOr {
+			groups[len(groups)-1] = append(groups[len(groups)-1], term)
+			pendingOr = false
+		} else {
+			groups = append(groups, []queryTerm{term})
+		}
+		return nil
+	}
+
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		term := queryTerm{}
+		if runes[i] == '-' || runes[i] == '!' {
+			term.negated = true
+			i++
+		}
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return "", fmt.Errorf("%w: missing closing quote", ErrInvalidSearch)
+			}
+			term.addWords(string(runes[i+1 : end]))
+			if len(term.lexemes) == 0 {
+				return "", fmt.Errorf("%w: empty phrase", ErrInvalidSearch)
+			}
+			i = end + 1
+		} else {
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' {
+				i++
+			}
+			word := string(runes[start:i])
+			if !term.negated && (word == "OR" || word == "|") {
+				if pendingOr || len(groups) == 0 {
+					return "", fmt.Errorf("%w: OR must be between two words", ErrInvalidSearch)
+				}
+				pendingOr = true
+				continue
+			}
+			term.addWords(word)
+		}
+		err := addTerm(term)
+		if err != nil {
+			return "", err
+		}
+	}
+	if pendingOr {
+		return "", fmt.Errorf("%w: OR must be between two words", ErrInvalidSearch)
+	}
+	if !hasPositive {
+		return "", fmt.Errorf("%w: query must contain a word that is not excluded", ErrInvalidSearch)
+	}
+
+	compiled := make([]string, len(groups))
+	for i, group := range groups {
+		parts := make([]string, len(group))
+		for j, term := range group {
+			parts[j] = term.tsQuery()
+		}
+		compiled[i] = strings.Join(parts, " | ")
+		if len(parts) > 1 {
+			compiled[i] = "(" + compiled[i] + ")"
+		}
+	}
+	return strings.Join(compiled, " & "), nil
+}
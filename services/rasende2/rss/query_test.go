@@ -0,0 +1,101 @@
+package rss
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// quotedLexeme matches a quoted lexeme of a compiled query, which must not contain quotes or backslashes
+var quotedLexeme = regexp.MustCompile(`'[^'\\]*'`)
+
+// assertSafeTsQuery fails if the compiled query has anything but quoted lexemes and tsquery operators
+func assertSafeTsQuery(t *testing.T, tsQuery string) {
+	t.Helper()
+	rest := quotedLexeme.ReplaceAllString(tsQuery, "")
+	if strings.Trim(rest, "&|!()<->:* ") != "" {
+		t.Errorf("compiled query %q has unquoted characters %q", tsQuery, rest)
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"word", "rasende", `'rasende'`},
+		{"words", "rasende politiker", `'rasende' & 'politiker'`},
+		{"extra spaces", "  rasende \t politiker  ", `'rasende' & 'politiker'`},
+		{"æøå", "Løkke æblegrød Århus", `'Løkke' & 'æblegrød' & 'Århus'`},
+		{"phrase", `"Mette Frederiksen"`, `('Mette' <-> 'Frederiksen')`},
+		{"phrase with æøå", `"grønne områder på Nørrebro"`, `('grønne' <-> 'områder' <-> 'på' <-> 'Nørrebro')`},
+		{"phrase and word", `rasende "Mette Frederiksen"`, `'rasende' & ('Mette' <-> 'Frederiksen')`},
+		{"or", "politiker OR minister", `('politiker' | 'minister')`},
+		{"or binds tighter than and", "rasende politiker OR minister", `'rasende' & ('politiker' | 'minister')`},
+		{"or with pipe", "politiker | minister", `('politiker' | 'minister')`},
+		{"or of several", "søren OR mette OR lars", `('søren' | 'mette' | 'lars')`},
+		{"or of phrases", `"Mette Frederiksen" OR statsminister`, `(('Mette' <-> 'Frederiksen') | 'statsminister')`},
+		{"lowercase or is a word", "politiker or minister", `'politiker' & 'or' & 'minister'`},
+		{"negation", "rasende -regeringen", `'rasende' & !'regeringen'`},
+		{"negation with !", "rasende !regeringen", `'rasende' & !'regeringen'`},
+		{"negated phrase", `rasende -"Mette Frederiksen"`, `'rasende' & !('Mette' <-> 'Frederiksen')`},
+		{"negated or is a word", "rasende -OR", `'rasende' & !'OR'`},
+		{"prefix", "rasend*", `'rasend':*`},
+		{"prefix with æøå", "skærp*", `'skærp':*`},
+		{"prefix in phrase", `"rasende politik*"`, `('rasende' <-> 'politik':*)`},
+		{"negated prefix", "rasende -minist*", `'rasende' & !'minist':*`},
+		{"hyphenated word is a phrase", "covid-19", `('covid' <-> '19')`},
+		{"ampersand", "rasende & politiker", `'rasende' & 'politiker'`},
+		{"pipe inside word", "rasende|politiker", `('rasende' <-> 'politiker')`},
+		{"parentheses", "(rasende) (politiker OR minister)", `'rasende' & ('politiker' | 'minister')`},
+		{"colon and star", "rasende:* politiker:A", `'rasende' & ('politiker' <-> 'A')`},
+		{"quote", "it's", `('it' <-> 's')`},
+		{"backslash", `back\slash \' \\`, `('back' <-> 'slash')`},
+		{"exclamation inside word", "rasende!!", `'rasende'`},
+		{"injection", `'; DROP TABLE rss_items; --`, `'DROP' & 'TABLE' & ('rss' <-> 'items')`},
+		{"tsquery syntax", `'rasende' & !'politiker' | ('minister' <-> 'x'):*`, `'rasende' & (!'politiker' | 'minister') & 'x'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseSearchQuery(tt.query)
+			if err != nil {
+				t.Fatalf("parseSearchQuery(%q) error = %v", tt.query, err)
+			}
+			if actual != tt.expected {
+				t.Errorf("parseSearchQuery(%q) = %v, expected %v", tt.query, actual, tt.expected)
+			}
+			assertSafeTsQuery(t, actual)
+		})
+	}
+}
+
+func TestParseSearchQueryInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"empty", ""},
+		{"only spaces", "   "},
+		{"only metacharacters", `& ! ( ) : * ' \`},
+		{"missing closing quote", `"Mette Frederiksen`},
+		{"empty phrase", `rasende ""`},
+		{"phrase without words", `rasende "&|!"`},
+		{"leading or", "OR rasende"},
+		{"trailing or", "rasende OR"},
+		{"double or", "rasende OR OR politiker"},
+		{"or before metacharacters", "rasende OR &"},
+		{"only negations", "-rasende -politiker"},
+		{"only negated phrase", `-"Mette Frederiksen"`},
+		{"too many words", "en to tre fire fem seks syv otte ni ti elleve"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseSearchQuery(tt.query)
+			if !errors.Is(err, ErrInvalidSearch) {
+				t.Errorf("parseSearchQuery(%q) = %q, %v, expected %v", tt.query, actual, err, ErrInvalidSearch)
+			}
+		})
+	}
+}
@@ -1,13 +1,16 @@
 package rss
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/bjarke-xyz/go-monorepo/libs/common/db"
 	"github.com/bjarke-xyz/rasende2/pkg"
+	"github.com/lib/pq"
 )
 
 type RssRepository struct {
@@ -20,15 +23,24 @@ func NewRssRepository(context *pkg.AppContext) *RssRepository {
 	}
 }
 
+// RssUrlDto is a source in rss.json. Only name and urls are required, so the other fields are left out
+// of the file when they have their default value.
 type RssUrlDto struct {
-	Name string   `json:"name"`
-	Urls []string `json:"urls"`
+	Name                   string   `json:"name"`
+	Urls                   []string `json:"urls"`
+	Categories             []string `json:"categories,omitempty"`
+	Enabled                *bool    `json:"enabled,omitempty"`
+	PollingIntervalMinutes int      `json:"pollingIntervalMinutes,omitempty"`
+	ExtractArticles        bool     `json:"extractArticles,omitempty"`
+	ContentRetentionMonths int      `json:"contentRetentionMonths,omitempty"`
+	Language               string   `json:"language,omitempty"`
 }
 
-func (r *RssRepository) GetRssUrls() ([]RssUrlDto, error) {
-	jsonBytes, err := os.ReadFile("rss.json")
+// GetRssUrlsFromFile reads sources from a json file in the format of rss.json
+func (r *RssRepository) GetRssUrlsFromFile(path string) ([]RssUrlDto, error) {
+	jsonBytes, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("could not load rss.json: %w", err)
+		return nil, fmt.Errorf("could not load %v: %w", path, err)
 	}
 	var rssUrls []RssUrlDto
 	err = json.Unmarshal(jsonBytes, &rssUrls)
@@ -38,16 +50,347 @@ func (r *RssRepository) GetRssUrls() ([]RssUrlDto, error) {
 	return rssUrls, nil
 }
 
+type RssSource struct {
+	Id                     int            `db:"id" json:"id"`
+	Name                   string         `db:"name" json:"name"`
+	Urls                   pq.StringArray `db:"urls" json:"urls"`
+	Categories             pq.StringArray `db:"categories" json:"categories"`
+	Enabled                bool           `db:"enabled" json:"enabled"`
+	PollingIntervalMinutes int            `db:"polling_interval_minutes" json:"pollingIntervalMinutes"`
+	// ExtractArticles enables fetching the article of each item, to search the full text
+	ExtractArticles bool `db:"extract_articles" json:"extractArticles"`
+	// ContentRetentionMonths is how long the content of items is kept, before it is archived and removed. 0 keeps it forever.
+	ContentRetentionMonths int `db:"content_retention_months" json:"contentRetentionMonths"`
+	// Language is da, sv, no or en, and decides the text search configuration of the items
+	Language string `db:"language" json:"language"`
+	// Proposed sources were found by feed discovery, and have not been reviewed
+	Proposed   bool       `db:"proposed" json:"proposed"`
+	LastPolled *time.Time `db:"last_polled" json:"lastPolled"`
+	CreatedAt  time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"updatedAt"`
+}
+
+// IsDue returns true if the source should be polled at the given time
+func (s *RssSource) IsDue(now time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	if s.LastPolled == nil {
+		return true
+	}
+	return !s.LastPolled.Add(time.Duration(s.PollingIntervalMinutes) * time.Minute).After(now)
+}
+
+var ErrSourceNotFound = errors.New("source not found")
+
+func (r *RssRepository) GetSources() ([]RssSource, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	sources := []RssSource{}
+	err = db.Select(&sources, "SELECT * FROM rss_sources ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error getting sources: %w", err)
+	}
+	return sources, nil
+}
+
+func (r *RssRepository) GetSource(id int) (*RssSource, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	source := RssSource{}
+	err = db.Get(&source, "SELECT * FROM rss_sources WHERE id = $1", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSourceNotFound
+		}
+		return nil, fmt.Errorf("error getting source %v: %w", id, err)
+	}
+	return &source, nil
+}
+
+func (r *RssRepository) InsertSource(source RssSource) (*RssSource, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.NamedQuery("INSERT INTO rss_sources (name, urls, categories, enabled, polling_interval_minutes, extract_articles, content_retention_months, language, proposed) "+
+		"VALUES (:name, :urls, :categories, :enabled, :polling_interval_minutes, :extract_articles, :content_retention_months, :language, :proposed) RETURNING *", source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert source: %w", err)
+	}
+	defer rows.Close()
+	inserted := RssSource{}
+	if rows.Next() {
+		err = rows.StructScan(&inserted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan inserted source: %w", err)
+		}
+	}
+	return &inserted, rows.Err()
+}
+
+func (r *RssRepository) UpdateSource(source RssSource) (*RssSource, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.NamedQuery("UPDATE rss_sources SET name = :name, urls = :urls, categories = :categories, enabled = :enabled, "+
+		"polling_interval_minutes = :polling_interval_minutes, extract_articles = :extract_articles, "+
+		"content_retention_months = :content_retention_months, language = :language, proposed = :proposed, updated_at = now() WHERE id = :id RETURNING *", source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update source %v: %w", source.Id, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+		return nil, ErrSourceNotFound
+	}
+	updated := RssSource{}
+	err = rows.StructScan(&updated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan updated source: %w", err)
+	}
+	return &updated, nil
+}
+
+func (r *RssRepository) DeleteSource(id int) error {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	result, err := db.Exec("DELETE FROM rss_sources WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete source %v: %w", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrSourceNotFound
+	}
+	return nil
+}
+
+func (r *RssRepository) SetSourceLastPolled(id int, lastPolled time.Time) error {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("UPDATE rss_sources SET last_polled = $2 WHERE id = $1", id, lastPolled)
+	if err != nil {
+		return fmt.Errorf("failed to set last polled for source %v: %w", id, err)
+	}
+	return nil
+}
+
+// ImportSources inserts the sources that do not exist yet, by name. Returns the number of inserted sources.
+func (r *RssRepository) ImportSources(sources []RssSource) (int, error) {
+	if len(sources) == 0 {
+		return 0, nil
+	}
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	result, err := db.NamedExec("INSERT INTO rss_sources (name, urls, categories, enabled, polling_interval_minutes, extract_articles, content_retention_months, language, proposed) "+
+		"VALUES (:name, :urls, :categories, :enabled, :polling_interval_minutes, :extract_articles, :content_retention_months, :language, :proposed) ON CONFLICT (name) DO NOTHING", sources)
+	if err != nil {
+		return 0, fmt.Errorf("failed to import sources: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return int(affected), nil
+}
+
 type RssItemDto struct {
-	ItemId    string    `db:"item_id" json:"itemId"`
-	SiteName  string    `db:"site_name" json:"siteName"`
-	Title     string    `db:"title" json:"title"`
-	Content   string    `db:"content" json:"content"`
-	Link      string    `db:"link" json:"link"`
-	Published time.Time `db:"published" json:"published"`
+	ItemId        string    `db:"item_id" json:"itemId"`
+	SiteName      string    `db:"site_name" json:"siteName"`
+	Title         string    `db:"title" json:"title"`
+	Content       string    `db:"content" json:"content"`
+	Link          string    `db:"link" json:"link"`
+	Published     time.Time `db:"published" json:"published"`
+	Guid          string    `db:"guid" json:"-"`
+	CanonicalLink string    `db:"canonical_link" json:"-"`
+	// ClusterId is shared by items that are near duplicates, e.g. the same story syndicated to several sites
+	ClusterId string        `db:"cluster_id" json:"clusterId"`
+	MinHash   pq.Int64Array `db:"minhash" json:"-"`
+	// ArticleWordCount and Paywalled are set when the article of the item has been extracted
+	ArticleWordCount int  `db:"article_word_count" json:"articleWordCount"`
+	Paywalled        bool `db:"paywalled" json:"paywalled"`
+	// Anger and Negativity are scored from 0 to 1 by the scorer named Scorer
+	Anger      float64 `db:"anger" json:"anger"`
+	Negativity float64 `db:"negativity" json:"negativity"`
+	Scorer     string  `db:"scorer" json:"-"`
+	// Language is the language of the source, and decides how the item is searched
+	Language string `db:"language" json:"language"`
+}
+
+func chartCountExpression(unique bool) string {
+	if unique {
+		return "count(DISTINCT cluster_id)"
+	}
+	return "count(*)"
+}
+
+// CountItemsByBucket counts the items with titles matching tsQuery, published in [from, to), grouped by date_trunc(bucket)
+// in chartTimezone. tsQuery must be compiled by parseSearchQuery.
+func (r *RssRepository) CountItemsByBucket(tsQuery string, bucket string, from time.Time, to time.Time, unique bool) ([]ChartCount, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	counts := []ChartCount{}
+	// the buckets are the wall clock time in chartTimezone
+	sql := "SELECT date_trunc($2, published AT TIME ZONE '" + chartTimezone + "') AS bucket, " + chartCountExpression(unique) + " AS count " +
+		"FROM rss_items WHERE " + tsMatchExpression("ts_title", "$1", nil) + " AND published >= $3 AND published < $4 GROUP BY 1 ORDER BY 1"
+	err = db.Select(&counts, sql, tsQuery, bucket, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error counting items with query %v: %w", tsQuery, err)
+	}
+	return counts, nil
 }
 
-func (r *RssRepository) SearchItems(query string, searchContent bool) ([]RssItemDto, error) {
+// CountItemsBySite counts the items with titles matching tsQuery, published in [from, to), at each site
+func (r *RssRepository) CountItemsBySite(tsQuery string, from time.Time, to time.Time, unique bool) ([]ChartCount, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	counts := []ChartCount{}
+	sql := "SELECT site_name, " + chartCountExpression(unique) + " AS count " +
+		"FROM rss_items WHERE " + tsMatchExpression("ts_title", "$1", nil) + " AND published >= $2 AND published < $3 GROUP BY site_name ORDER BY site_name"
+	err = db.Select(&counts, sql, tsQuery, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error counting items by site with query %v: %w", tsQuery, err)
+	}
+	return counts, nil
+}
+
+// SearchItemsPage returns a page of the items matching the search, starting after the cursor, and the total number of matches
+func (r *RssRepository) SearchItemsPage(params SearchParams, cursor *searchCursor) ([]SearchHit, int, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+
+	args := []any{params.tsQuery}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%v", len(args))
+	}
+	// the matches are limited to params.Languages by tsMatchExpression
+	q := tsItemQuery("language", "$1")
+	rank := "ts_rank(ts_title, " + q + ")"
+	where := tsMatchExpression("ts_title", "$1", params.Languages)
+	if params.SearchContent {
+		// the sum is float8, so it is cast back to real, the type of the cursor rank, for the cursor comparison to be exact
+		rank = "(ts_rank(ts_title, " + q + ") + ts_rank(coalesce(ts_content, ''::tsvector), " + q + ") * 0.5)::real"
+		where = "(" + tsMatchExpression("ts_title", "$1", params.Languages) + " OR " + tsMatchExpression("ts_content", "$1", params.Languages) + ")"
+	}
+	if len(params.Sites) > 0 {
+		where = where + " AND site_name = ANY(" + arg(pq.Array(params.Sites)) + ")"
+	}
+	if !params.From.IsZero() {
+		where = where + " AND published >= " + arg(params.From) + "::timestamptz"
+	}
+	if !params.To.IsZero() {
+		where = where + " AND published < " + arg(params.To) + "::timestamptz"
+	}
+	matches := "SELECT item_id, site_name, title, coalesce(content, '') AS content, coalesce(link, '') AS link, published, cluster_id, " +
+		"article_word_count, paywalled, anger, negativity, coalesce(article_text, '') AS article_text, language, " +
+		rank + " AS rank FROM rss_items WHERE " + where
+
+	var total int
+	err = db.Get(&total, "SELECT count(*) FROM ("+matches+") m", args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error counting items with query %v: %w", params.Query, err)
+	}
+
+	pageWhere := "true"
+	orderBy := "published DESC, item_id DESC"
+	if params.Sort == SearchSortRelevance {
+		orderBy = "rank DESC, item_id DESC"
+		if cursor != nil {
+			cursorRank := arg(cursor.Rank) + "::real"
+			pageWhere = "(rank < " + cursorRank + " OR (rank = " + cursorRank + " AND item_id < " + arg(cursor.ItemId) + "))"
+		}
+	} else if cursor != nil {
+		pageWhere = "(published, item_id) < (" + arg(cursor.Published) + "::timestamptz, " + arg(cursor.ItemId) + ")"
+	}
+	headlineOptions := "'StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxWords=35, MinWords=15, MaxFragments=2'"
+	contentHighlight := "''"
+	itemQuery := tsItemQuery("m.language", "$1")
+	if params.SearchContent {
+		contentHighlight = "ts_headline(rss_search_config(m.language), m.content || ' ' || m.article_text, " + itemQuery + ", " + headlineOptions + ")"
+	}
+	// ts_headline is slow, so it is only calculated for the items on the page
+	sql := "SELECT m.item_id, m.site_name, m.title, m.content, m.link, m.published, m.cluster_id, m.article_word_count, m.paywalled, m.anger, m.negativity, " +
+		"m.language, m.rank, ts_headline(rss_search_config(m.language), m.title, " + itemQuery + ", " + headlineOptions + ") AS title_highlight, " +
+		contentHighlight + " AS content_highlight " +
+		"FROM (" + matches + ") m WHERE " + pageWhere +
+		" ORDER BY " + orderBy + " LIMIT " + arg(params.Limit)
+	hits := []SearchHit{}
+	err = db.Select(&hits, sql, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting items with query %v: %w", params.Query, err)
+	}
+	return hits, total, nil
+}
+
+// InsertItems inserts the items that do not exist already, and returns the ids of the inserted items
+func (r *RssRepository) InsertItems(items []RssItemDto) ([]string, error) {
+	insertedIds := []string{}
+	if len(items) == 0 {
+		return insertedIds, nil
+	}
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// A single statement is atomic, so no transaction is needed
+	rows, err := db.NamedQuery("INSERT INTO rss_items (item_id, site_name, title, content, link, published, guid, canonical_link, cluster_id, minhash, anger, negativity, scorer, language) "+
+		"values (:item_id, :site_name, :title, :content, :link, :published, :guid, :canonical_link, :cluster_id, :minhash, :anger, :negativity, :scorer, :language) on conflict do nothing returning item_id", items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var itemId string
+		err = rows.Scan(&itemId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan inserted id: %w", err)
+		}
+		insertedIds = append(insertedIds, itemId)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to insert: %w", err)
+	}
+	return insertedIds, nil
+}
+
+// GetExistingItems returns the items of the site that have one of the ids, guids or canonical links
+func (r *RssRepository) GetExistingItems(siteName string, ids []string, guids []string, links []string) ([]RssItemDto, error) {
 	db, err := db.Connect(r.context.Config)
 	if err != nil {
 		return nil, err
@@ -55,20 +398,18 @@ func (r *RssRepository) SearchItems(query string, searchContent bool) ([]RssItem
 	db = db.Unsafe()
 	defer db.Close()
 	var rssItems []RssItemDto
-	sql := "SELECT * FROM rss_items WHERE ts_title @@ to_tsquery('danish', $1)"
-	if searchContent {
-		sql = sql + " OR ts_content @@ to_tsquery('danish', $1)"
-	}
-	sql = sql + " ORDER BY published DESC"
-	// err = db.Select(&rssItems, "SELECT * FROM rss_items WHERE LOWER(title) LIKE '%' || $1 || '%' order by published desc", query)
-	err = db.Select(&rssItems, sql, query)
+	err = db.Select(&rssItems, "SELECT item_id, site_name, title, coalesce(link, '') AS link, guid, canonical_link, cluster_id FROM rss_items "+
+		"WHERE site_name = $1 AND (item_id = ANY($2) OR (guid <> '' AND guid = ANY($3)) OR (canonical_link <> '' AND canonical_link = ANY($4)))",
+		siteName, pq.Array(ids), pq.Array(guids), pq.Array(links))
 	if err != nil {
-		return nil, fmt.Errorf("error getting items with query %v: %w", query, err)
+		return nil, fmt.Errorf("error getting existing items for site %v: %w", siteName, err)
 	}
 	return rssItems, nil
 }
 
-func (r *RssRepository) GetItems(siteName string) ([]RssItemDto, error) {
+// GetItemsWithoutCanonicalLink returns the ids and links of items with a link but no canonical link,
+// ordered by id and starting after afterItemId
+func (r *RssRepository) GetItemsWithoutCanonicalLink(afterItemId string, limit int) ([]RssItemDto, error) {
 	db, err := db.Connect(r.context.Config)
 	if err != nil {
 		return nil, err
@@ -76,14 +417,16 @@ func (r *RssRepository) GetItems(siteName string) ([]RssItemDto, error) {
 	db = db.Unsafe()
 	defer db.Close()
 	var rssItems []RssItemDto
-	err = db.Select(&rssItems, "SELECT * FROM rss_items WHERE site_name = $1", siteName)
+	err = db.Select(&rssItems, "SELECT item_id, coalesce(link, '') AS link FROM rss_items WHERE canonical_link = '' AND item_id > $1 AND coalesce(link, '') <> '' "+
+		"ORDER BY item_id LIMIT $2", afterItemId, limit)
 	if err != nil {
-		return nil, fmt.Errorf("error getting items for site %v: %w", siteName, err)
+		return nil, fmt.Errorf("error getting items without canonical link: %w", err)
 	}
 	return rssItems, nil
 }
 
-func (r *RssRepository) InsertItems(items []RssItemDto) error {
+// SetCanonicalLinks stores the canonical links of the items
+func (r *RssRepository) SetCanonicalLinks(items []RssItemDto) error {
 	if len(items) == 0 {
 		return nil
 	}
@@ -92,21 +435,541 @@ func (r *RssRepository) InsertItems(items []RssItemDto) error {
 		return err
 	}
 	defer db.Close()
+	ids := make([]string, len(items))
+	links := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ItemId
+		links[i] = item.CanonicalLink
+	}
+	_, err = db.Exec("UPDATE rss_items SET canonical_link = l.canonical_link FROM unnest($1::text[], $2::text[]) AS l(item_id, canonical_link) "+
+		"WHERE rss_items.item_id = l.item_id", pq.Array(ids), pq.Array(links))
+	if err != nil {
+		return fmt.Errorf("failed to set canonical links: %w", err)
+	}
+	return nil
+}
 
-	tx, err := db.Begin()
+// GetRecentSignatures returns the MinHash signatures of items published after since, for finding near duplicates
+func (r *RssRepository) GetRecentSignatures(since time.Time) ([]RssItemDto, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var rssItems []RssItemDto
+	err = db.Select(&rssItems, "SELECT item_id, cluster_id, minhash FROM rss_items WHERE published > $1 AND minhash IS NOT NULL", since)
+	if err != nil {
+		return nil, fmt.Errorf("error getting recent signatures: %w", err)
+	}
+	return rssItems, nil
+}
+
+type RssItemRevision struct {
+	ItemId    string    `db:"item_id" json:"itemId"`
+	Title     string    `db:"title" json:"title"`
+	Link      string    `db:"link" json:"link"`
+	ChangedAt time.Time `db:"changed_at" json:"changedAt"`
+}
+
+// UpdateItemTitles stores the new titles of the items, and keeps the previous titles as revisions
+func (r *RssRepository) UpdateItemTitles(items []RssItemDto, previous []RssItemRevision) error {
+	if len(items) == 0 {
+		return nil
+	}
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Beginx()
 	if err != nil {
 		return fmt.Errorf("failed to begin tx: %w", err)
 	}
-	_, err = db.NamedExec("INSERT INTO rss_items (item_id, site_name, title, content, link, published) "+
-		"values (:item_id, :site_name, :title, :content, :link, :published) on conflict do nothing", items)
+	_, err = tx.NamedExec("INSERT INTO rss_item_revisions (item_id, title, link, changed_at) VALUES (:item_id, :title, :link, :changed_at)", previous)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to insert: %w", err)
+		return fmt.Errorf("failed to insert revisions: %w", err)
+	}
+	for _, item := range items {
+		_, err = tx.NamedExec("UPDATE rss_items SET title = :title, link = :link, canonical_link = :canonical_link, minhash = :minhash, "+
+			"anger = :anger, negativity = :negativity, scorer = :scorer WHERE item_id = :item_id", item)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to update item %v: %w", item.ItemId, err)
+		}
 	}
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to commit tx: %w", err)
 	}
 	return nil
+}
+
+func (r *RssRepository) GetFetchValidators(urls []string) (map[string]FetchValidators, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var validators []FetchValidators
+	err = db.Select(&validators, "SELECT * FROM rss_fetch_validators WHERE url = ANY($1)", pq.Array(urls))
+	if err != nil {
+		return nil, fmt.Errorf("error getting fetch validators: %w", err)
+	}
+	validatorsByUrl := make(map[string]FetchValidators)
+	for _, v := range validators {
+		validatorsByUrl[v.Url] = v
+	}
+	return validatorsByUrl, nil
+}
+
+func (r *RssRepository) SaveFetchValidators(validators []FetchValidators) error {
+	if len(validators) == 0 {
+		return nil
+	}
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.NamedExec("INSERT INTO rss_fetch_validators (url, etag, last_modified, updated_at) "+
+		"VALUES (:url, :etag, :last_modified, :updated_at) "+
+		"ON CONFLICT (url) DO UPDATE SET etag = excluded.etag, last_modified = excluded.last_modified, updated_at = excluded.updated_at", validators)
+	if err != nil {
+		return fmt.Errorf("failed to save fetch validators: %w", err)
+	}
+	return nil
+}
+
+func (r *RssRepository) SetSourceEnabled(id int, enabled bool) error {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("UPDATE rss_sources SET enabled = $2, updated_at = now() WHERE id = $1", id, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to set enabled for source %v: %w", id, err)
+	}
+	return nil
+}
+
+// GetSourcesHealth returns the health of all sources, including sources that have not been fetched yet
+func (r *RssRepository) GetSourcesHealth() ([]SourceHealth, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	health := []SourceHealth{}
+	err = db.Select(&health, "SELECT s.id AS source_id, s.name, s.enabled, h.last_success, h.last_failure, "+
+		"COALESCE(h.consecutive_failures, 0) AS consecutive_failures, COALESCE(h.last_error, '') AS last_error, "+
+		"COALESCE(h.last_item_count, 0) AS last_item_count, COALESCE(h.last_new_item_count, 0) AS last_new_item_count, "+
+		"h.last_new_items_at, h.next_attempt, COALESCE(h.auto_disabled, false) AS auto_disabled "+
+		"FROM rss_sources s LEFT JOIN rss_source_health h ON h.source_id = s.id ORDER BY s.name")
+	if err != nil {
+		return nil, fmt.Errorf("error getting source health: %w", err)
+	}
+	return health, nil
+}
+
+func (r *RssRepository) SaveSourceHealth(health SourceHealth) error {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.NamedExec("INSERT INTO rss_source_health (source_id, last_success, last_failure, consecutive_failures, last_error, "+
+		"last_item_count, last_new_item_count, last_new_items_at, next_attempt, auto_disabled) "+
+		"VALUES (:source_id, :last_success, :last_failure, :consecutive_failures, :last_error, "+
+		":last_item_count, :last_new_item_count, :last_new_items_at, :next_attempt, :auto_disabled) "+
+		"ON CONFLICT (source_id) DO UPDATE SET last_success = excluded.last_success, last_failure = excluded.last_failure, "+
+		"consecutive_failures = excluded.consecutive_failures, last_error = excluded.last_error, "+
+		"last_item_count = excluded.last_item_count, last_new_item_count = excluded.last_new_item_count, "+
+		"last_new_items_at = excluded.last_new_items_at, next_attempt = excluded.next_attempt, auto_disabled = excluded.auto_disabled", health)
+	if err != nil {
+		return fmt.Errorf("failed to save health for source %v: %w", health.SourceId, err)
+	}
+	return nil
+}
+
+// ResetSourceHealth clears the failures of a source, so a source that has been backing off or auto disabled is due on the next run
+func (r *RssRepository) ResetSourceHealth(id int) error {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("UPDATE rss_source_health SET consecutive_failures = 0, next_attempt = NULL, auto_disabled = false WHERE source_id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to reset health of source %v: %w", id, err)
+	}
+	return nil
+}
+
+// GetLatestTermFrequencyDay returns the latest day with term frequencies, or nil if there are none
+func (r *RssRepository) GetLatestTermFrequencyDay() (*time.Time, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var latest *time.Time
+	err = db.Get(&latest, "SELECT max(day) FROM rss_term_frequencies")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest term frequency day: %w", err)
+	}
+	return latest, nil
+}
+
+// ComputeTermFrequencies replaces the term frequencies of the day starting at from, with the lexemes of the items published in [from, to).
+// Short and numeric lexemes are skipped.
+func (r *RssRepository) ComputeTermFrequencies(from time.Time, to time.Time) (int64, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	day := from.Format("2006-01-02")
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM rss_term_frequencies WHERE day = $1", day)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete term frequencies of %v: %w", day, err)
+	}
+	result, err := tx.Exec("INSERT INTO rss_term_frequencies (day, site_name, term, title_count, content_count) "+
+		"SELECT $1::date, site_name, term, sum(in_title), sum(in_content) FROM ("+
+		"SELECT site_name, (unnest(ts_title)).lexeme AS term, 1 AS in_title, 0 AS in_content FROM rss_items WHERE published >= $2 AND published < $3 "+
+		"UNION ALL "+
+		"SELECT site_name, (unnest(ts_content)).lexeme AS term, 0 AS in_title, 1 AS in_content FROM rss_items WHERE published >= $2 AND published < $3 AND ts_content IS NOT NULL"+
+		") t WHERE length(term) > 2 AND term !~ '^[0-9]+$' GROUP BY site_name, term", day, from.UTC(), to.UTC())
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to insert term frequencies of %v: %w", day, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetTrendingTerms returns the terms with the highest rate per day in [recentStart, today] compared to [baselineStart, recentStart)
+func (r *RssRepository) GetTrendingTerms(params TrendingParams, baselineStart time.Time, recentStart time.Time) ([]TrendingTerm, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	count := "title_count"
+	if params.SearchContent {
+		count = "title_count + content_count"
+	}
+	// 1 is added to the rates, so terms that were not mentioned in the baseline do not divide by zero
+	sql := "SELECT term, recent_count, baseline_count, " +
+		"((recent_count::float8 / $3) + 1) / ((baseline_count::float8 / $4) + 1) AS score FROM (" +
+		"SELECT term, coalesce(sum(" + count + ") FILTER (WHERE day >= $2::date), 0) AS recent_count, " +
+		"coalesce(sum(" + count + ") FILTER (WHERE day < $2::date), 0) AS baseline_count " +
+		"FROM rss_term_frequencies WHERE day >= $1::date AND ($5 = '' OR site_name = $5) GROUP BY term" +
+		") t WHERE recent_count >= $6 ORDER BY score DESC, term LIMIT $7"
+	terms := []TrendingTerm{}
+	err = db.Select(&terms, sql, baselineStart.Format("2006-01-02"), recentStart.Format("2006-01-02"),
+		params.Days, params.BaselineDays, params.Site, minTrendingCount, params.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trending terms: %w", err)
+	}
+	return terms, nil
+}
+
+// GetRageCounts returns the number of items, and items with titles matching tsQuery, per site per week since from
+func (r *RssRepository) GetRageCounts(tsQuery string, from time.Time) ([]RageCount, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	sql := "SELECT date_trunc('week', published AT TIME ZONE '" + chartTimezone + "') AS week, site_name, " +
+		"count(*) FILTER (WHERE " + tsMatchExpression("ts_title", "$1", nil) + ") AS rage_count, count(*) AS item_count " +
+		"FROM rss_items WHERE published >= $2 GROUP BY 1, 2 ORDER BY 1 DESC"
+	counts := []RageCount{}
+	err = db.Select(&counts, sql, tsQuery, from.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rage counts: %w", err)
+	}
+	return counts, nil
+}
+
+// GetCooccurringTerms returns the lexemes used the most in titles matching tsQuery published since from.
+// The lexemes of the words in query itself are left out.
+func (r *RssRepository) GetCooccurringTerms(tsQuery string, query string, from time.Time, limit int) ([]CooccurringTerm, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	sql := "SELECT term, count(*) AS count FROM (" +
+		"SELECT (unnest(ts_title)).lexeme AS term FROM rss_items WHERE " + tsMatchExpression("ts_title", "$1", nil) + " AND published >= $2" +
+		") t WHERE length(term) > 2 AND term !~ '^[0-9]+$' AND term <> ALL(tsvector_to_array(" + tsVectorExpression("$3") + ")) " +
+		"GROUP BY term ORDER BY count DESC, term LIMIT $4"
+	terms := []CooccurringTerm{}
+	err = db.Select(&terms, sql, tsQuery, from.UTC(), query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get terms co-occurring with %v: %w", tsQuery, err)
+	}
+	return terms, nil
+}
+
+// GetItemsWithoutArticle returns the newest items published since, from sources with article extraction enabled,
+// whose article has not been fetched, or has failed with a temporary error
+func (r *RssRepository) GetItemsWithoutArticle(since time.Time, limit int) ([]ItemArticle, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	items := []ItemArticle{}
+	err = db.Select(&items, "SELECT i.item_id, coalesce(i.link, '') AS link, i.article_attempts FROM rss_items i JOIN rss_sources s ON s.name = i.site_name "+
+		"WHERE s.extract_articles AND i.article_fetched_at IS NULL AND i.published > $1 ORDER BY i.published DESC LIMIT $2", since.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get items without article: %w", err)
+	}
+	return items, nil
+}
+
+func (r *RssRepository) SaveArticles(articles []ItemArticle) error {
+	if len(articles) == 0 {
+		return nil
+	}
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, article := range articles {
+		_, err = tx.NamedExec("UPDATE rss_items SET article_text = :article_text, article_word_count = :article_word_count, paywalled = :paywalled, "+
+			"article_fetched_at = :article_fetched_at, article_error = :article_error, article_attempts = :article_attempts WHERE item_id = :item_id", article)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to save article of %v: %w", article.ItemId, err)
+		}
+	}
+	return tx.Commit()
+}
 
+// GetItemsToScore returns items that were not scored by the scorer
+func (r *RssRepository) GetItemsToScore(scorer string, limit int) ([]RssItemDto, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	items := []RssItemDto{}
+	// items without content would get a lower score than they had
+	err = db.Select(&items, "SELECT item_id, title, coalesce(content, '') AS content, language FROM rss_items WHERE scorer <> $1 AND content_pruned_at IS NULL "+
+		"ORDER BY published DESC LIMIT $2", scorer, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get items to score: %w", err)
+	}
+	return items, nil
+}
+
+func (r *RssRepository) SaveItemScores(items []RssItemDto) error {
+	if len(items) == 0 {
+		return nil
+	}
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		_, err = tx.NamedExec("UPDATE rss_items SET anger = :anger, negativity = :negativity, scorer = :scorer WHERE item_id = :item_id", item)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to save score of %v: %w", item.ItemId, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// AverageScoresByBucket returns the average scores of the items published in [from, to), grouped by date_trunc(bucket)
+// in chartTimezone. Items that have not been scored, or are not in the language of the lexicon, are left out.
+func (r *RssRepository) AverageScoresByBucket(bucket string, from time.Time, to time.Time, sites []string) ([]ItemScoreAverage, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	averages := []ItemScoreAverage{}
+	sql := "SELECT date_trunc($1, published AT TIME ZONE '" + chartTimezone + "') AS bucket, " +
+		"avg(anger) AS anger, avg(negativity) AS negativity, count(*) AS count FROM rss_items " +
+		"WHERE published >= $2 AND published < $3 AND scorer <> '' AND language = '" + scoredLanguage + "' " +
+		"AND (cardinality($4::text[]) = 0 OR site_name = ANY($4)) GROUP BY 1 ORDER BY 1"
+	err = db.Select(&averages, sql, bucket, from.UTC(), to.UTC(), pq.Array(sites))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get average scores: %w", err)
+	}
+	return averages, nil
+}
+
+// AverageScoresBySite returns the average scores of the items published in [from, to) at each site, angriest first.
+// Sites without items in the language of the lexicon are left out.
+func (r *RssRepository) AverageScoresBySite(from time.Time, to time.Time, sites []string) ([]ItemScoreAverage, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	averages := []ItemScoreAverage{}
+	sql := "SELECT site_name, avg(anger) AS anger, avg(negativity) AS negativity, count(*) AS count FROM rss_items " +
+		"WHERE published >= $1 AND published < $2 AND scorer <> '' AND language = '" + scoredLanguage + "' " +
+		"AND (cardinality($3::text[]) = 0 OR site_name = ANY($3)) " +
+		"GROUP BY site_name ORDER BY anger DESC, site_name"
+	err = db.Select(&averages, sql, from.UTC(), to.UTC(), pq.Array(sites))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get average scores by site: %w", err)
+	}
+	return averages, nil
+}
+
+// GetItemsToPrune returns the items of the site published before the time, whose content has not been pruned,
+// leaving out items whose content has been restored unless includeRestored is set
+func (r *RssRepository) GetItemsToPrune(siteName string, before time.Time, includeRestored bool, limit int) ([]ArchivedItem, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	items := []ArchivedItem{}
+	err = db.Select(&items, "SELECT item_id, site_name, title, coalesce(link, '') AS link, published, coalesce(content, '') AS content, "+
+		"coalesce(article_text, '') AS article_text FROM rss_items WHERE site_name = $1 AND published < $2 AND content_pruned_at IS NULL "+
+		"AND ($3 OR content_restored_at IS NULL) ORDER BY published LIMIT $4", siteName, before, includeRestored, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get items to prune of %v: %w", siteName, err)
+	}
+	return items, nil
+}
+
+// PruneItemContent removes the content and article of the items, and keeps the title, link and publish time
+func (r *RssRepository) PruneItemContent(ids []string, prunedAt time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("UPDATE rss_items SET content = '', article_text = NULL, content_pruned_at = $2, content_restored_at = NULL WHERE item_id = ANY($1)", pq.Array(ids), prunedAt)
+	if err != nil {
+		return fmt.Errorf("failed to prune content of items: %w", err)
+	}
+	return nil
+}
+
+// RestoreItemContent puts the archived content and article back on the items that still exist, and marks them as restored,
+// so they are not pruned again
+func (r *RssRepository) RestoreItemContent(items []ArchivedItem) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	restored := 0
+	for _, item := range items {
+		result, err := tx.NamedExec("UPDATE rss_items SET content = :content, article_text = nullif(:article_text, ''), content_pruned_at = NULL, "+
+			"content_restored_at = now() WHERE item_id = :item_id", item)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to restore content of %v: %w", item.ItemId, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		restored += int(affected)
+	}
+	return restored, tx.Commit()
+}
+
+// searchIndexes are the indexes of the search vectors
+var searchIndexes = []string{"ts_title_idx", "ts_content_idx"}
+
+// UpdateItemLanguages sets the language of at most limit items to the language of their source, which rebuilds
+// their search vectors, and returns the number of updated items
+func (r *RssRepository) UpdateItemLanguages(limit int) (int, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	result, err := db.Exec("UPDATE rss_items i SET language = s.language FROM rss_sources s WHERE i.site_name = s.name AND i.item_id IN ("+
+		"SELECT m.item_id FROM rss_items m JOIN rss_sources ms ON m.site_name = ms.name WHERE m.language <> ms.language LIMIT $1)", limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update item languages: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return int(affected), nil
+}
+
+// ReindexSearch rebuilds the indexes of the search vectors, without locking the table for writes
+func (r *RssRepository) ReindexSearch() error {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, index := range searchIndexes {
+		_, err := db.Exec("REINDEX INDEX CONCURRENTLY " + index)
+		if err != nil {
+			return fmt.Errorf("failed to reindex %v: %w", index, err)
+		}
+	}
+	_, err = db.Exec("ANALYZE rss_items")
+	if err != nil {
+		return fmt.Errorf("failed to analyze rss_items: %w", err)
+	}
+	return nil
+}
+
+// MatchItems returns the ids of the items that match tsQuery
+func (r *RssRepository) MatchItems(ids []string, tsQuery string, searchContent bool) ([]string, error) {
+	db, err := db.Connect(r.context.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	where := tsMatchExpression("ts_title", "$1", nil)
+	if searchContent {
+		where = "(" + where + " OR " + tsMatchExpression("ts_content", "$1", nil) + ")"
+	}
+	matchingIds := []string{}
+	err = db.Select(&matchingIds, "SELECT item_id FROM rss_items WHERE item_id = ANY($2) AND "+where, tsQuery, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to match items with query %v: %w", tsQuery, err)
+	}
+	return matchingIds, nil
 }
@@ -0,0 +1,180 @@
+package rss
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	JobIdentifierRetention = "RASENDE2_RETENTION_JOB"
+
+	// archiveBucket is the storage bucket pruned content is archived in
+	archiveBucket = "rasende2"
+	// archivePrefix is the prefix of the keys of the archives, followed by the escaped site name
+	archivePrefix = "archive/items/"
+	// retentionBatchSize is the number of items in each archive
+	retentionBatchSize = 1000
+)
+
+// ArchivedItem is a line of an archive. The title, link and publish time are kept in the database,
+// but are archived too, so an archive can be read on its own.
+type ArchivedItem struct {
+	ItemId      string    `db:"item_id" json:"itemId"`
+	SiteName    string    `db:"site_name" json:"siteName"`
+	Title       string    `db:"title" json:"title"`
+	Link        string    `db:"link" json:"link"`
+	Published   time.Time `db:"published" json:"published"`
+	Content     string    `db:"content" json:"content"`
+	ArticleText string    `db:"article_text" json:"articleText"`
+}
+
+type RetentionJob struct {
+	service *RssService
+}
+
+func NewRetentionJob(service *RssService) *RetentionJob {
+	return &RetentionJob{
+		service: service,
+	}
+}
+
+func (j *RetentionJob) ExecuteJob() error {
+	_, err := j.service.PruneContent(context.Background(), time.Now(), false)
+	return err
+}
+
+// archiveSitePrefix is the prefix of the archives of the site
+func archiveSitePrefix(siteName string) string {
+	return archivePrefix + url.PathEscape(siteName) + "/"
+}
+
+// PruneContent archives and removes the content of items older than the retention of their source,
+// and returns the number of pruned items. Content is only removed after its archive has been stored.
+// Items whose content has been restored are left alone, unless includeRestored is set.
+func (r *RssService) PruneContent(ctx context.Context, now time.Time, includeRestored bool) (int, error) {
+	sources, err := r.repository.GetSources()
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, source := range sources {
+		if source.ContentRetentionMonths <= 0 {
+			continue
+		}
+		before := now.AddDate(0, -source.ContentRetentionMonths, 0)
+		for batch := 0; ; batch++ {
+			items, err := r.repository.GetItemsToPrune(source.Name, before, includeRestored, retentionBatchSize)
+			if err != nil {
+				return pruned, err
+			}
+			if len(items) == 0 {
+				break
+			}
+			key := fmt.Sprintf("%v%v-%03d.ndjson.gz", archiveSitePrefix(source.Name), now.UTC().Format("20060102T150405Z"), batch)
+			err = r.archiveItems(ctx, key, items)
+			if err != nil {
+				return pruned, err
+			}
+			ids := make([]string, len(items))
+			for i, item := range items {
+				ids[i] = item.ItemId
+			}
+			err = r.repository.PruneItemContent(ids, now)
+			if err != nil {
+				return pruned, err
+			}
+			pruned += len(items)
+			log.Printf("PruneContent: archived %v items of %v to %v", len(items), source.Name, key)
+			if len(items) < retentionBatchSize {
+				break
+			}
+		}
+	}
+	log.Printf("PruneContent: pruned the content of %v items", pruned)
+	if pruned > 0 {
+		r.invalidateCache(ctx)
+	}
+	return pruned, nil
+}
+
+// archiveItems stores the items as gzipped NDJSON
+func (r *RssService) archiveItems(ctx context.Context, key string, items []ArchivedItem) error {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gzipWriter)
+	for _, item := range items {
+		err := encoder.Encode(item)
+		if err != nil {
+			return fmt.Errorf("failed to encode archived item %v: %w", item.ItemId, err)
+		}
+	}
+	err := gzipWriter.Close()
+	if err != nil {
+		return fmt.Errorf("failed to compress archive %v: %w", key, err)
+	}
+	err = r.context.Storage.Put(ctx, archiveBucket, key, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to store archive %v: %w", key, err)
+	}
+	return nil
+}
+
+// ListArchives returns the keys of the archives of the site, or of all sites if siteName is empty
+func (r *RssService) ListArchives(ctx context.Context, siteName string) ([]string, error) {
+	prefix := archivePrefix
+	if siteName != "" {
+		prefix = archiveSitePrefix(siteName)
+	}
+	return r.context.Storage.List(ctx, archiveBucket, prefix)
+}
+
+// RestoreArchive puts the content in the archive back on its items, and returns the number of restored items.
+// Items that have been deleted since they were archived are skipped. Restored items are not pruned again by the retention job.
+func (r *RssService) RestoreArchive(ctx context.Context, key string) (int, error) {
+	if !strings.HasPrefix(key, archivePrefix) {
+		return 0, fmt.Errorf("%q is not an archive, archives start with %v", key, archivePrefix)
+	}
+	data, err := r.context.Storage.Get(ctx, archiveBucket, key)
+	if err != nil {
+		return 0, err
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decompress archive %v: %w", key, err)
+	}
+	defer gzipReader.Close()
+	items := make([]ArchivedItem, 0)
+	scanner := bufio.NewScanner(gzipReader)
+	// article texts can be longer than the default max line length
+	scanner.Buffer(make([]byte, 0, 64*1024), maxArticleSize*2)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		item := ArchivedItem{}
+		err := json.Unmarshal(scanner.Bytes(), &item)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode line %v of archive %v: %w", len(items)+1, key, err)
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read archive %v: %w", key, err)
+	}
+	restored, err := r.repository.RestoreItemContent(items)
+	if err != nil {
+		return 0, err
+	}
+	if restored > 0 {
+		r.invalidateCache(ctx)
+	}
+	return restored, nil
+}
@@ -0,0 +1,286 @@
+package rss
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JobIdentifierScoring = "RASENDE2_SCORING_JOB"
+
+	// rescoreBatchSize is the number of items scored on each run, when the scorer has changed
+	rescoreBatchSize = 5000
+	// contentScoreWeight is how much the content counts compared to the title
+	contentScoreWeight = 0.25
+	// intensifierWeight multiplies the score of the word after an intensifier
+	intensifierWeight = 1.5
+	// scoredLanguage is the language of the lexicon. Items in other languages get a score of 0, and are left out of the averages.
+	scoredLanguage = LanguageDanish
+)
+
+//go:embed lexicon_da.tsv
+var defaultLexicon string
+
+// negations cancel the score of the word after them
+var negations = map[string]bool{"ikke": true, "aldrig": true, "ingen": true, "intet": true}
+
+// intensifiers increase the score of the word after them
+var intensifiers = map[string]bool{"meget": true, "voldsomt": true, "enormt": true, "helt": true, "totalt": true, "dybt": true, "stærkt": true}
+
+// ItemScore is how angry and negative an item is, from 0 to 1
+type ItemScore struct {
+	Anger      float64
+	Negativity float64
+}
+
+// ItemScorer scores items at ingest. Name is stored with the scores, so items are rescored when the scorer changes.
+type ItemScorer interface {
+	Name() string
+	Score(title string, content string) ItemScore
+}
+
+type lexiconEntry struct {
+	anger      float64
+	negativity float64
+}
+
+// LexiconScorer scores items by looking up their words in a lexicon, so it runs offline
+type LexiconScorer struct {
+	name     string
+	words    map[string]lexiconEntry
+	prefixes []string
+	prefixed map[string]lexiconEntry
+}
+
+// NewLexiconScorer parses a lexicon of lines with a word, an anger score and a negativity score, separated by tabs.
+// Words ending in * match all words starting with them. Empty lines and lines starting with # are skipped.
+func NewLexiconScorer(name string, lexicon io.Reader) (*LexiconScorer, error) {
+	scorer := &LexiconScorer{
+		name:     name,
+		words:    make(map[string]lexiconEntry),
+		prefixes: make([]string, 0),
+		prefixed: make(map[string]lexiconEntry),
+	}
+	scanner := bufio.NewScanner(lexicon)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %v of lexicon %v must have 3 tab separated fields", lineNumber, name)
+		}
+		anger, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid anger on line %v of lexicon %v: %w", lineNumber, name, err)
+		}
+		negativity, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid negativity on line %v of lexicon %v: %w", lineNumber, name, err)
+		}
+		entry := lexiconEntry{anger: anger, negativity: negativity}
+		word := strings.ToLower(fields[0])
+		if strings.HasSuffix(word, "*") {
+			prefix := strings.TrimSuffix(word, "*")
+			scorer.prefixes = append(scorer.prefixes, prefix)
+			scorer.prefixed[prefix] = entry
+		} else {
+			scorer.words[word] = entry
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read lexicon %v: %w", name, err)
+	}
+	// the longest prefix is the most specific
+	sort.Slice(scorer.prefixes, func(i, j int) bool {
+		return len(scorer.prefixes[i]) > len(scorer.prefixes[j])
+	})
+	return scorer, nil
+}
+
+// NewDefaultScorer returns the scorer using the danish lexicon in lexicon_da.tsv, or the lexicon in the file
+// at path, if path is not empty
+func NewDefaultScorer(path string) (ItemScorer, error) {
+	if path == "" {
+		return NewLexiconScorer("lexicon-da-2", strings.NewReader(defaultLexicon))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lexicon: %w", err)
+	}
+	defer f.Close()
+	return NewLexiconScorer("lexicon:"+path, f)
+}
+
+func (s *LexiconScorer) Name() string {
+	return s.name
+}
+
+func (s *LexiconScorer) lookup(word string) (lexiconEntry, bool) {
+	if entry, ok := s.words[word]; ok {
+		return entry, true
+	}
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(word, prefix) {
+			return s.prefixed[prefix], true
+		}
+	}
+	return lexiconEntry{}, false
+}
+
+func (s *LexiconScorer) scoreText(text string) ItemScore {
+	score := ItemScore{}
+	words := strings.Fields(normalizeText(text))
+	for i, word := range words {
+		entry, ok := s.lookup(word)
+		if !ok {
+			continue
+		}
+		if i > 0 && negations[words[i-1]] {
+			continue
+		}
+		weight := 1.0
+		if i > 0 && intensifiers[words[i-1]] {
+			weight = intensifierWeight
+		}
+		score.Anger += entry.anger * weight
+		score.Negativity += entry.negativity * weight
+	}
+	return score
+}
+
+func clampScore(score float64) float64 {
+	if score > 1 {
+		return 1
+	}
+	return score
+}
+
+func (s *LexiconScorer) Score(title string, content string) ItemScore {
+	contentRunes := []rune(content)
+	if len(contentRunes) > maxShingleContentLength {
+		contentRunes = contentRunes[:maxShingleContentLength]
+	}
+	titleScore := s.scoreText(title)
+	contentScore := s.scoreText(string(contentRunes))
+	return ItemScore{
+		Anger:      clampScore(titleScore.Anger + contentScore.Anger*contentScoreWeight),
+		Negativity: clampScore(titleScore.Negativity + contentScore.Negativity*contentScoreWeight),
+	}
+}
+
+func (r *RssService) scoreItem(item *RssItemDto) {
+	// the lexicon is danish, so items in other languages are not scored, and get a score of 0
+	score := ItemScore{}
+	if item.Language == "" || item.Language == scoredLanguage {
+		score = r.scorer.Score(item.Title, item.Content)
+	}
+	item.Anger = score.Anger
+	item.Negativity = score.Negativity
+	item.Scorer = r.scorer.Name()
+}
+
+type ScoringJob struct {
+	service *RssService
+}
+
+func NewScoringJob(service *RssService) *ScoringJob {
+	return &ScoringJob{
+		service: service,
+	}
+}
+
+func (s *ScoringJob) ExecuteJob() error {
+	return s.service.RescoreItems()
+}
+
+// RescoreItems scores a batch of the items that were scored by another scorer, or before scoring was added
+func (r *RssService) RescoreItems() error {
+	items, err := r.repository.GetItemsToScore(r.scorer.Name(), rescoreBatchSize)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		r.scoreItem(&items[i])
+	}
+	err = r.repository.SaveItemScores(items)
+	if err != nil {
+		return err
+	}
+	log.Printf("RescoreItems: scored %v items with %v", len(items), r.scorer.Name())
+	if len(items) > 0 {
+		r.invalidateCache(context.Background())
+	}
+	return nil
+}
+
+// ScoreChartParams are the period and bucket of the anger charts
+type ScoreChartParams struct {
+	From   time.Time
+	To     time.Time
+	Bucket string
+	Sites  []string
+}
+
+// ItemScoreAverage is the average score of the items in a bucket, or at a site
+type ItemScoreAverage struct {
+	Bucket     time.Time `db:"bucket"`
+	SiteName   string    `db:"site_name"`
+	Anger      float64   `db:"anger"`
+	Negativity float64   `db:"negativity"`
+	Count      int       `db:"count"`
+}
+
+type ScoreSeries struct {
+	Labels     []string
+	Anger      []float64
+	Negativity []float64
+	// Sites are the average scores of each site in the period, angriest first
+	Sites []ItemScoreAverage
+}
+
+// GetScoreSeries returns the average anger and negativity in each bucket of the period, and of each site
+func (r *RssService) GetScoreSeries(ctx context.Context, params ScoreChartParams) (*ScoreSeries, error) {
+	period, err := ChartParams{From: params.From, To: params.To, Bucket: params.Bucket}.validatePeriod(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	series := &ScoreSeries{}
+	cacheKey := r.cacheKey(ctx, fmt.Sprintf("ScoreSeries:%v:%v:%v:%v:%v", period.start().Unix(), period.end().Unix(), period.Bucket, strings.Join(params.Sites, ","), r.scorer.Name()))
+	if err := r.context.Cache.Get(ctx, cacheKey, series); err == nil {
+		return series, nil
+	}
+	averages, err := r.repository.AverageScoresByBucket(period.Bucket, period.start(), period.end(), params.Sites)
+	if err != nil {
+		return nil, err
+	}
+	byLabel := make(map[string]ItemScoreAverage)
+	for _, average := range averages {
+		byLabel[period.label(average.Bucket)] = average
+	}
+	series.Labels = period.buckets()
+	series.Anger = make([]float64, len(series.Labels))
+	series.Negativity = make([]float64, len(series.Labels))
+	for i, label := range series.Labels {
+		series.Anger[i] = byLabel[label].Anger
+		series.Negativity[i] = byLabel[label].Negativity
+	}
+	series.Sites, err = r.repository.AverageScoresBySite(period.start(), period.end(), params.Sites)
+	if err != nil {
+		return nil, err
+	}
+	r.context.Cache.Set(ctx, cacheKey, series, time.Hour)
+	return series, nil
+}
@@ -0,0 +1,67 @@
+package rss
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const testLexicon = `# test lexicon
+rasende	0.9	0.5
+ras*	0.1	0.1
+raseri*	1.0	0.8
+vred	0.6	0.4
+`
+
+func TestLexiconScorerScore(t *testing.T) {
+	scorer, err := NewLexiconScorer("test", strings.NewReader(testLexicon))
+	if err != nil {
+		t.Fatalf("NewLexiconScorer() error = %v", err)
+	}
+	tests := []struct {
+		name     string
+		title    string
+		content  string
+		expected ItemScore
+	}{
+		{"no words", "", "", ItemScore{}},
+		{"unknown words", "regeringen holder pressemøde", "", ItemScore{}},
+		{"word", "Borgere er vred", "", ItemScore{Anger: 0.6, Negativity: 0.4}},
+		{"case and punctuation", "VRED!", "", ItemScore{Anger: 0.6, Negativity: 0.4}},
+		{"negation", "ikke vred", "", ItemScore{}},
+		{"negation only cancels the next word", "ikke glad, men vred", "", ItemScore{Anger: 0.6, Negativity: 0.4}},
+		{"intensifier", "meget vred", "", ItemScore{Anger: 0.9, Negativity: 0.6}},
+		{"exact word before prefix", "rasende", "", ItemScore{Anger: 0.9, Negativity: 0.5}},
+		{"longest prefix", "raseriet", "", ItemScore{Anger: 1.0, Negativity: 0.8}},
+		{"shorter prefix", "rasmus", "", ItemScore{Anger: 0.1, Negativity: 0.1}},
+		{"content counts less", "", "vred", ItemScore{Anger: 0.6 * contentScoreWeight, Negativity: 0.4 * contentScoreWeight}},
+		{"clamped to 1", "rasende vred", "", ItemScore{Anger: 1, Negativity: 0.9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := scorer.Score(tt.title, tt.content)
+			if math.Abs(actual.Anger-tt.expected.Anger) > 1e-9 || math.Abs(actual.Negativity-tt.expected.Negativity) > 1e-9 {
+				t.Errorf("Score(%q, %q) = %+v, expected %+v", tt.title, tt.content, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDefaultLexicon(t *testing.T) {
+	scorer, err := NewDefaultScorer("")
+	if err != nil {
+		t.Fatalf("NewDefaultScorer() error = %v", err)
+	}
+	neutral := []string{"harmoni", "harmonisk", "protestantisk", "protestantismen", "demonstrerer", "demonstrere"}
+	for _, word := range neutral {
+		if score := scorer.Score(word, ""); score != (ItemScore{}) {
+			t.Errorf("Score(%q) = %+v, expected no score", word, score)
+		}
+	}
+	angry := []string{"rasende", "harme", "harmdirrende", "protesterer", "demonstranter"}
+	for _, word := range angry {
+		if score := scorer.Score(word, ""); score.Anger == 0 {
+			t.Errorf("Score(%q) = %+v, expected an anger score", word, score)
+		}
+	}
+}
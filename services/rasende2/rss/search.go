@@ -0,0 +1,178 @@
+package rss
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	SearchSortRecency   = "recency"
+	SearchSortRelevance = "relevance"
+
+	defaultSearchLimit = 5
+	maxSearchLimit     = 100
+
+	// highlightStart and highlightStop are put around matched words by ts_headline
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+)
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+type SearchParams struct {
+	Query         string
+	SearchContent bool
+	Sites         []string
+	// Languages limit the items to these languages, and the query to their text search configurations
+	Languages []string
+	// From and To limit the published date, zero means no limit
+	From   time.Time
+	To     time.Time
+	Sort   string
+	Limit  int
+	Cursor string
+	// tsQuery is the query compiled by parseSearchQuery
+	tsQuery string
+}
+
+// searchCursor points at the last item of a page, the next page starts after it
+type searchCursor struct {
+	Published time.Time `json:"p"`
+	Rank      float32   `json:"r"`
+	ItemId    string    `json:"id"`
+}
+
+func (c searchCursor) encode() string {
+	bytes, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeSearchCursor(cursor string) (*searchCursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidSearch)
+	}
+	c := &searchCursor{}
+	err = json.Unmarshal(bytes, c)
+	if err != nil || c.ItemId == "" {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidSearch)
+	}
+	return c, nil
+}
+
+type SearchHit struct {
+	RssItemDto
+	Rank             float32 `db:"rank" json:"rank"`
+	TitleHighlight   string  `db:"title_highlight" json:"titleHighlight"`
+	ContentHighlight string  `db:"content_highlight" json:"contentHighlight"`
+}
+
+type SearchPage struct {
+	Hits       []SearchHit
+	Total      int
+	NextCursor string
+}
+
+func (p SearchParams) validate() (SearchParams, error) {
+	p.Query = strings.TrimSpace(p.Query)
+	if len(p.Query) > 50 || len(p.Query) <= 2 {
+		return p, fmt.Errorf("%w: query must be between 3 and 50 characters", ErrInvalidSearch)
+	}
+	tsQuery, err := parseSearchQuery(p.Query)
+	if err != nil {
+		return p, err
+	}
+	p.tsQuery = tsQuery
+	p.Languages, err = validateLanguages(p.Languages)
+	if err != nil {
+		return p, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+	if p.Sort == "" {
+		p.Sort = SearchSortRecency
+	}
+	if p.Sort != SearchSortRecency && p.Sort != SearchSortRelevance {
+		return p, fmt.Errorf("%w: sort must be %v or %v", ErrInvalidSearch, SearchSortRecency, SearchSortRelevance)
+	}
+	if p.Limit <= 0 {
+		p.Limit = defaultSearchLimit
+	}
+	if p.Limit > maxSearchLimit {
+		p.Limit = maxSearchLimit
+	}
+	if !p.From.IsZero() && !p.To.IsZero() && p.To.Before(p.From) {
+		return p, fmt.Errorf("%w: to must not be before from", ErrInvalidSearch)
+	}
+	return p, nil
+}
+
+func (r *RssService) Search(ctx context.Context, params SearchParams) (*SearchPage, error) {
+	params, err := params.validate()
+	if err != nil {
+		return nil, err
+	}
+	var cursor *searchCursor
+	if params.Cursor != "" {
+		cursor, err = decodeSearchCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	page := &SearchPage{}
+	cacheKey := r.cacheKey(ctx, fmt.Sprintf("Search:%v:%v:%v:%v:%v:%v:%v:%v:%v", params.Query, params.SearchContent, strings.Join(params.Sites, ","),
+		strings.Join(params.Languages, ","), params.From.Unix(), params.To.Unix(), params.Sort, params.Limit, params.Cursor))
+	if err := r.context.Cache.Get(ctx, cacheKey, page); err == nil {
+		return page, nil
+	}
+	hits, total, err := r.repository.SearchItemsPage(params, cursor)
+	if err != nil {
+		return nil, err
+	}
+	page.Hits = hits
+	page.Total = total
+	if len(hits) == params.Limit {
+		last := hits[len(hits)-1]
+		page.NextCursor = searchCursor{
+			Published: last.Published,
+			Rank:      last.Rank,
+			ItemId:    last.ItemId,
+		}.encode()
+	}
+	r.context.Cache.Set(ctx, cacheKey, page, time.Hour)
+	return page, nil
+}
+
+// ReindexSearch sets the language of the items to the language of their source, which rebuilds their search vectors,
+// and rebuilds the search indexes, e.g. after a bulk import or a change of the search configuration
+func (r *RssService) ReindexSearch() error {
+	_, err := r.UpdateItemLanguages()
+	if err != nil {
+		return err
+	}
+	return r.repository.ReindexSearch()
+}
+
+var highlightRegexp = regexp.MustCompile(regexp.QuoteMeta(highlightStart) + "(.*?)" + regexp.QuoteMeta(highlightStop))
+
+// highlightedWords returns the distinct words that were highlighted in the hits
+func highlightedWords(hits []SearchHit) []string {
+	seen := make(map[string]bool)
+	words := make([]string, 0)
+	for _, hit := range hits {
+		for _, text := range []string{hit.TitleHighlight, hit.ContentHighlight} {
+			for _, match := range highlightRegexp.FindAllStringSubmatch(text, -1) {
+				word := strings.ToLower(match[1])
+				if !seen[word] {
+					seen[word] = true
+					words = append(words, word)
+				}
+			}
+		}
+	}
+	return words
+}
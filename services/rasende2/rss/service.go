@@ -2,14 +2,15 @@ package rss
 
 import (
 	"context"
-	"crypto/md5"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bjarke-xyz/rasende2/duda"
 	"github.com/bjarke-xyz/rasende2/pkg"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/mmcdole/gofeed"
@@ -19,92 +20,128 @@ type RssService struct {
 	context    *pkg.AppContext
 	repository *RssRepository
 	sanitizer  *bluemonday.Policy
+	httpClient *http.Client
+	scorer     ItemScorer
+	// httpCache is the cache feeds are fetched through, or nil if they are fetched directly
+	httpCache *duda.Cache
 }
 
-func NewRssService(context *pkg.AppContext, repository *RssRepository) *RssService {
+func NewRssService(context *pkg.AppContext, repository *RssRepository, scorer ItemScorer, httpCache *duda.Cache) *RssService {
 	return &RssService{
 		context:    context,
 		repository: repository,
+		scorer:     scorer,
+		httpCache:  httpCache,
 		sanitizer:  bluemonday.StrictPolicy(),
+		httpClient: &http.Client{
+			Timeout: fetchTimeout,
+		},
 	}
 }
 
-func getItemId(item *gofeed.Item) string {
-	str := item.Title + ":" + item.Link
-	bytes := []byte(str)
-	hashedBytes := md5.Sum(bytes)
-	hashStr := fmt.Sprintf("%x", hashedBytes)
-	return hashStr
-}
-
-func (r *RssService) convertToDto(feedItem *gofeed.Item, rssUrl RssUrlDto) RssItemDto {
+func (r *RssService) convertToDto(feedItem *gofeed.Item, source RssSource) RssItemDto {
 	published := feedItem.PublishedParsed
 	if published == nil {
 		now := time.Now()
 		published = &now
 	}
-	return RssItemDto{
-		ItemId:    getItemId(feedItem),
-		SiteName:  rssUrl.Name,
-		Title:     feedItem.Title,
-		Content:   strings.TrimSpace(r.sanitizer.Sanitize(feedItem.Content)),
-		Link:      feedItem.Link,
-		Published: *published,
+	itemId := getItemId(source.Name, feedItem)
+	content := strings.TrimSpace(r.sanitizer.Sanitize(feedItem.Content))
+	item := RssItemDto{
+		ItemId:        itemId,
+		SiteName:      source.Name,
+		Title:         feedItem.Title,
+		Content:       content,
+		Link:          feedItem.Link,
+		Published:     published.UTC(),
+		Guid:          strings.TrimSpace(feedItem.GUID),
+		CanonicalLink: canonicalLink(feedItem.Link),
+		ClusterId:     itemId,
+		MinHash:       minHash(feedItem.Title, content),
+		Language:      source.Language,
 	}
+	r.scoreItem(&item)
+	return item
+}
+
+// FetchAndSaveNewItems fetches the sources that are due, and not backing off after failures
+func (r *RssService) FetchAndSaveNewItems() error {
+	return r.fetchAndSaveSources(false)
+}
+
+// FetchAndSaveAllItems fetches all enabled sources now, also if they are not due or are backing off
+func (r *RssService) FetchAndSaveAllItems() error {
+	return r.fetchAndSaveSources(true)
 }
 
-func (r *RssService) SearchItems(ctx context.Context, query string, searchContent bool) ([]RssItemDto, error) {
-	var items []RssItemDto = []RssItemDto{}
-	if len(query) > 50 || len(query) <= 2 {
-		return items, nil
+func (r *RssService) fetchAndSaveSources(force bool) error {
+	// existing items must have canonical links before new items are matched against them
+	_, err := r.BackfillCanonicalLinks()
+	if err != nil {
+		return fmt.Errorf("failed to backfill canonical links: %w", err)
 	}
-	cacheKey := fmt.Sprintf("SearchItems:%v:%v", query, searchContent)
-	if err := r.context.Cache.Get(ctx, cacheKey, &items); err == nil {
-		return items, nil
+	// the items of a source whose language was changed are given the new language here, and not in the request
+	// changing it, since it rebuilds the search vectors of all the items of the source
+	_, err = r.UpdateItemLanguages()
+	if err != nil {
+		return fmt.Errorf("failed to update item languages: %w", err)
 	}
-	items, err := r.repository.SearchItems(query, searchContent)
-	if err == nil {
-		r.context.Cache.Set(ctx, cacheKey, items, time.Hour)
+	sources, err := r.repository.GetSources()
+	if err != nil {
+		return fmt.Errorf("failed to get sources: %w", err)
 	}
-	return items, err
-}
-
-func (r *RssService) FetchAndSaveNewItems() error {
-	rssUrls, err := r.repository.GetRssUrls()
+	sourcesHealth, err := r.repository.GetSourcesHealth()
 	if err != nil {
-		return fmt.Errorf("failed to get rss urls: %w", err)
+		return fmt.Errorf("failed to get source health: %w", err)
 	}
-	errors := make([]error, 0)
-	for _, rssUrl := range rssUrls {
-		toInsert := make([]RssItemDto, 0)
-		existing, err := r.repository.GetItems(rssUrl.Name)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to get items for %v: %w", rssUrl.Name, err))
+	healthBySource := make(map[int]*SourceHealth)
+	for i, health := range sourcesHealth {
+		healthBySource[health.SourceId] = &sourcesHealth[i]
+	}
+	now := time.Now()
+	dueSources := make([]RssSource, 0, len(sources))
+	for _, source := range sources {
+		if !source.Enabled {
 			continue
 		}
-		existingIds := make(map[string]bool)
-		for _, item := range existing {
-			existingIds[item.ItemId] = true
+		if !force && !source.IsDue(now) {
+			continue
 		}
-
-		fromFeed, err := r.parse(rssUrl)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to get items from feed %v: %w", rssUrl.Name, err))
+		if health, ok := healthBySource[source.Id]; !force && ok && health.isBackingOff(now) {
 			continue
 		}
-		for _, item := range fromFeed {
-			_, exists := existingIds[item.ItemId]
-			if !exists {
-				toInsert = append(toInsert, item)
+		dueSources = append(dueSources, source)
+	}
+
+	errorsCh := make(chan error, len(dueSources))
+	semaphore := make(chan struct{}, fetchConcurrency)
+	var wg sync.WaitGroup
+	var changed int32
+	for _, source := range dueSources {
+		wg.Add(1)
+		go func(source RssSource) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			result, err := r.fetchAndSaveSource(source, now)
+			r.recordHealth(source, healthBySource[source.Id], result, err, now)
+			if err != nil {
+				errorsCh <- err
 			}
-		}
+			if result.newItemCount > 0 || result.changedItemCount > 0 {
+				atomic.StoreInt32(&changed, 1)
+			}
+		}(source)
+	}
+	wg.Wait()
+	close(errorsCh)
+	if atomic.LoadInt32(&changed) == 1 {
+		r.invalidateCache(context.Background())
+	}
 
-		log.Printf("FetchAndSaveNewItems: %v inserted %v new items", rssUrl.Name, len(toInsert))
-		err = r.repository.InsertItems(toInsert)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to insert items for %v: %w", rssUrl.Name, err))
-			continue
-		}
+	errors := make([]error, 0)
+	for err := range errorsCh {
+		errors = append(errors, err)
 	}
 	if len(errors) > 0 {
 		err := errors[0]
@@ -119,22 +156,62 @@ func (r *RssService) FetchAndSaveNewItems() error {
 	return nil
 }
 
-func (r *RssService) parse(rssUrl RssUrlDto) ([]RssItemDto, error) {
-	contents, err := r.getContents(rssUrl)
+func (r *RssService) fetchAndSaveSource(source RssSource, now time.Time) (fetchResult, error) {
+	err := r.repository.SetSourceLastPolled(source.Id, now)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get content for site %v: %w", rssUrl.Name, err)
+		return fetchResult{}, err
+	}
+	fromFeed, validators, err := r.parse(source)
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("failed to get items from feed %v: %w", source.Name, err)
+	}
+
+	newItems, changedItemCount, err := r.updateExistingItems(source, fromFeed, now)
+	if err != nil {
+		return fetchResult{}, err
+	}
+	err = r.assignClusters(newItems, now)
+	if err != nil {
+		return fetchResult{}, err
+	}
+
+	// Items inserted by a concurrent run are skipped by the database, so only the new items are returned
+	insertedIds, err := r.repository.InsertItems(newItems)
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("failed to insert items for %v: %w", source.Name, err)
+	}
+	log.Printf("FetchAndSaveNewItems: %v inserted %v new items", source.Name, len(insertedIds))
+	r.publishNewItems(context.Background(), newItems, insertedIds)
+	// Validators are only saved once the items are stored, otherwise a failed insert would never be retried
+	err = r.repository.SaveFetchValidators(validators)
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("failed to save fetch validators for %v: %w", source.Name, err)
+	}
+	return fetchResult{
+		itemCount:        len(fromFeed),
+		newItemCount:     len(insertedIds),
+		changedItemCount: changedItemCount,
+	}, nil
+}
+
+func (r *RssService) parse(source RssSource) ([]RssItemDto, []FetchValidators, error) {
+	contents, err := r.getContents(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get content for site %v: %w", source.Name, err)
 	}
 	parsed := make([]RssItemDto, 0)
+	validators := make([]FetchValidators, 0, len(contents))
 	fp := gofeed.NewParser()
 	seenIds := make(map[string]bool)
 	for _, content := range contents {
-		feed, err := fp.ParseString(content)
+		feed, err := fp.ParseString(content.body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse site %v: %w", rssUrl.Name, err)
+			return nil, nil, fmt.Errorf("failed to parse site %v: %w", source.Name, err)
 		}
+		validators = append(validators, content.validators)
 
 		for _, item := range feed.Items {
-			dto := r.convertToDto(item, rssUrl)
+			dto := r.convertToDto(item, source)
 			_, hasSeen := seenIds[dto.ItemId]
 			if !hasSeen {
 				parsed = append(parsed, dto)
@@ -142,32 +219,29 @@ func (r *RssService) parse(rssUrl RssUrlDto) ([]RssItemDto, error) {
 			}
 		}
 	}
-	return parsed, nil
+	return parsed, validators, nil
 
 }
 
-func (r *RssService) getContents(rssUrl RssUrlDto) ([]string, error) {
-	contents := make([]string, 0)
-	for _, url := range rssUrl.Urls {
-		req, err := http.NewRequest(http.MethodGet, url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
+// getContents returns the contents of the urls of the source that have changed since they were last fetched
+func (r *RssService) getContents(source RssSource) ([]feedContent, error) {
+	storedValidators, err := r.repository.GetFetchValidators(source.Urls)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]feedContent, 0)
+	for _, url := range source.Urls {
+		var validators *FetchValidators
+		if v, ok := storedValidators[url]; ok {
+			validators = &v
 		}
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		content, err := r.fetchFeed(url, validators)
 		if err != nil {
-			return nil, fmt.Errorf("error getting %v: %w", url, err)
-		}
-		if resp.StatusCode > 299 {
-			return nil, fmt.Errorf("error getting %v, returned error code %v", url, resp.StatusCode)
+			return nil, err
 		}
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error reading body of %v: %w", url, err)
+		if content != nil {
+			contents = append(contents, *content)
 		}
-		bodyStr := string(body)
-		contents = append(contents, bodyStr)
 	}
 	return contents, nil
 }
@@ -0,0 +1,236 @@
+package rss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultPollingIntervalMinutes = 60
+	minPollingIntervalMinutes     = 5
+)
+
+var ErrInvalidSource = errors.New("invalid source")
+
+func (r RssUrlDto) toSource() RssSource {
+	source := RssSource{
+		Name:                   r.Name,
+		Urls:                   r.Urls,
+		Categories:             r.Categories,
+		Enabled:                true,
+		PollingIntervalMinutes: r.PollingIntervalMinutes,
+		ExtractArticles:        r.ExtractArticles,
+		ContentRetentionMonths: r.ContentRetentionMonths,
+		Language:               r.Language,
+	}
+	if r.Enabled != nil {
+		source.Enabled = *r.Enabled
+	}
+	return source
+}
+
+func (s RssSource) toRssUrl() RssUrlDto {
+	rssUrl := RssUrlDto{
+		Name:                   s.Name,
+		Urls:                   s.Urls,
+		Categories:             s.Categories,
+		ExtractArticles:        s.ExtractArticles,
+		ContentRetentionMonths: s.ContentRetentionMonths,
+	}
+	if !s.Enabled {
+		rssUrl.Enabled = &s.Enabled
+	}
+	if s.Language != defaultLanguage {
+		rssUrl.Language = s.Language
+	}
+	if s.PollingIntervalMinutes != defaultPollingIntervalMinutes {
+		rssUrl.PollingIntervalMinutes = s.PollingIntervalMinutes
+	}
+	return rssUrl
+}
+
+func validateSource(source *RssSource) error {
+	source.Name = strings.TrimSpace(source.Name)
+	if source.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidSource)
+	}
+	if len(source.Urls) == 0 {
+		return fmt.Errorf("%w: at least one url is required", ErrInvalidSource)
+	}
+	for _, rawUrl := range source.Urls {
+		u, err := url.Parse(rawUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: %q is not a http(s) url", ErrInvalidSource, rawUrl)
+		}
+	}
+	if source.Categories == nil {
+		source.Categories = []string{}
+	}
+	for i, category := range source.Categories {
+		source.Categories[i] = strings.ToLower(strings.TrimSpace(category))
+	}
+	if source.PollingIntervalMinutes == 0 {
+		source.PollingIntervalMinutes = defaultPollingIntervalMinutes
+	}
+	if source.PollingIntervalMinutes < minPollingIntervalMinutes {
+		return fmt.Errorf("%w: polling interval must be at least %v minutes", ErrInvalidSource, minPollingIntervalMinutes)
+	}
+	source.Language = strings.ToLower(strings.TrimSpace(source.Language))
+	if source.Language == "" {
+		source.Language = defaultLanguage
+	}
+	if !isLanguage(source.Language) {
+		return fmt.Errorf("%w: language must be one of %v", ErrInvalidSource, strings.Join(Languages, ", "))
+	}
+	if source.ContentRetentionMonths < 0 {
+		return fmt.Errorf("%w: content retention must be 0, to keep content forever, or a number of months", ErrInvalidSource)
+	}
+	return nil
+}
+
+func (r *RssService) GetSources() ([]RssSource, error) {
+	return r.repository.GetSources()
+}
+
+func (r *RssService) GetSource(id int) (*RssSource, error) {
+	return r.repository.GetSource(id)
+}
+
+func (r *RssService) CreateSource(source RssSource) (*RssSource, error) {
+	err := validateSource(&source)
+	if err != nil {
+		return nil, err
+	}
+	return r.repository.InsertSource(source)
+}
+
+func (r *RssService) UpdateSource(source RssSource) (*RssSource, error) {
+	err := validateSource(&source)
+	if err != nil {
+		return nil, err
+	}
+	if source.Enabled {
+		// enabling a proposed source is the review
+		source.Proposed = false
+	}
+	previous, err := r.repository.GetSource(source.Id)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := r.repository.UpdateSource(source)
+	if err != nil {
+		return nil, err
+	}
+	if updated.Enabled && !previous.Enabled {
+		// A source that is enabled again, should be tried right away, and not be disabled again by its old failures
+		err = r.repository.ResetSourceHealth(updated.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return updated, nil
+}
+
+func (r *RssService) DeleteSource(id int) error {
+	return r.repository.DeleteSource(id)
+}
+
+// ImportSourcesFromFile imports the sources in a json file in the format of rss.json.
+// Sources that already exist, by name, are left untouched.
+func (r *RssService) ImportSourcesFromFile(path string) (int, error) {
+	rssUrls, err := r.repository.GetRssUrlsFromFile(path)
+	if err != nil {
+		return 0, err
+	}
+	sources := make([]RssSource, 0, len(rssUrls))
+	for _, rssUrl := range rssUrls {
+		source := rssUrl.toSource()
+		err := validateSource(&source)
+		if err != nil {
+			return 0, fmt.Errorf("invalid source %q in %v: %w", rssUrl.Name, path, err)
+		}
+		sources = append(sources, source)
+	}
+	return r.repository.ImportSources(sources)
+}
+
+// ExportSources writes the sources as json in the format of rss.json, so they can be imported with ImportSourcesFromFile.
+// Proposed sources are left out, since they have not been reviewed.
+func (r *RssService) ExportSources(w io.Writer) (int, error) {
+	sources, err := r.repository.GetSources()
+	if err != nil {
+		return 0, err
+	}
+	rssUrls := make([]RssUrlDto, 0, len(sources))
+	for _, source := range sources {
+		if !source.Proposed {
+			rssUrls = append(rssUrls, source.toRssUrl())
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(rssUrls)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write sources: %w", err)
+	}
+	return len(rssUrls), nil
+}
+
+// ImportSourcesIfEmpty does a one-time import of the sources in the file, if there are no sources in the database
+func (r *RssService) ImportSourcesIfEmpty(path string) error {
+	sources, err := r.repository.GetSources()
+	if err != nil {
+		return err
+	}
+	if len(sources) > 0 {
+		return nil
+	}
+	imported, err := r.ImportSourcesFromFile(path)
+	if err != nil {
+		return err
+	}
+	log.Printf("imported %v sources from %v", imported, path)
+	return nil
+}
+
+// ProposeSources adds the sources as proposed and disabled, for review. Sources with a name or url that is
+// already used by a source are skipped. It returns the number of proposed sources.
+func (r *RssService) ProposeSources(proposed []RssSource) (int, error) {
+	existing, err := r.repository.GetSources()
+	if err != nil {
+		return 0, err
+	}
+	existingUrls := make(map[string]bool)
+	for _, source := range existing {
+		for _, url := range source.Urls {
+			existingUrls[canonicalLink(url)] = true
+		}
+	}
+	sources := make([]RssSource, 0, len(proposed))
+	for _, source := range proposed {
+		source.Enabled = false
+		source.Proposed = true
+		err := validateSource(&source)
+		if err != nil {
+			log.Printf("skipping invalid proposed source %q: %v", source.Name, err)
+			continue
+		}
+		urls := make([]string, 0, len(source.Urls))
+		for _, url := range source.Urls {
+			if !existingUrls[canonicalLink(url)] {
+				urls = append(urls, url)
+			}
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		source.Urls = urls
+		sources = append(sources, source)
+	}
+	return r.repository.ImportSources(sources)
+}
@@ -0,0 +1,155 @@
+package rss
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// newItemsChannel is the redis channel inserted items are published to, so every replica can stream them
+	newItemsChannel = "rss:new-items"
+	// streamBufferSize is the number of items a slow subscriber can be behind, before items are dropped
+	streamBufferSize = 100
+	// streamResubscribeDelay is the wait before subscribing again, if the redis subscription ends
+	streamResubscribeDelay = 5 * time.Second
+	// streamKeepAliveInterval is how often a ping is sent, so proxies do not close idle streams
+	streamKeepAliveInterval = 30 * time.Second
+)
+
+// publishNewItems publishes the inserted items to all replicas
+func (r *RssService) publishNewItems(ctx context.Context, items []RssItemDto, insertedIds []string) {
+	if len(insertedIds) == 0 {
+		return
+	}
+	inserted := make(map[string]bool, len(insertedIds))
+	for _, id := range insertedIds {
+		inserted[id] = true
+	}
+	published := make([]RssItemDto, 0, len(insertedIds))
+	for _, item := range items {
+		if inserted[item.ItemId] {
+			item.MinHash = nil
+			published = append(published, item)
+		}
+	}
+	message, err := json.Marshal(published)
+	if err != nil {
+		log.Printf("failed to marshal new items: %v", err)
+		return
+	}
+	r.context.Cache.Publish(ctx, newItemsChannel, string(message))
+}
+
+type streamSubscriber struct {
+	tsQuery       string
+	searchContent bool
+	items         chan RssItemDto
+}
+
+type streamQuery struct {
+	tsQuery       string
+	searchContent bool
+}
+
+// ItemStream fans the new items published by any replica out to the subscribers on this replica, whose query they match
+type ItemStream struct {
+	service     *RssService
+	mu          sync.Mutex
+	subscribers map[*streamSubscriber]bool
+}
+
+func NewItemStream(service *RssService) *ItemStream {
+	return &ItemStream{
+		service:     service,
+		subscribers: make(map[*streamSubscriber]bool),
+	}
+}
+
+// Run receives the new items from redis, and should be run in its own goroutine
+func (s *ItemStream) Run() {
+	ctx := context.Background()
+	for {
+		for message := range s.service.context.Cache.Subscribe(ctx, newItemsChannel) {
+			items := []RssItemDto{}
+			err := json.Unmarshal([]byte(message), &items)
+			if err != nil {
+				log.Printf("failed to unmarshal new items: %v", err)
+				continue
+			}
+			s.dispatch(items)
+		}
+		log.Printf("subscription to %v ended, subscribing again in %v", newItemsChannel, streamResubscribeDelay)
+		time.Sleep(streamResubscribeDelay)
+	}
+}
+
+// Subscribe returns the new items matching the query, until unsubscribe is called
+func (s *ItemStream) Subscribe(query string, searchContent bool) (<-chan RssItemDto, func(), error) {
+	params, err := SearchParams{Query: query}.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	subscriber := &streamSubscriber{
+		tsQuery:       params.tsQuery,
+		searchContent: searchContent,
+		items:         make(chan RssItemDto, streamBufferSize),
+	}
+	s.mu.Lock()
+	s.subscribers[subscriber] = true
+	s.mu.Unlock()
+	unsubscribe := func() {
+		s.mu.Lock()
+		delete(s.subscribers, subscriber)
+		s.mu.Unlock()
+	}
+	return subscriber.items, unsubscribe, nil
+}
+
+// dispatch sends the items to the subscribers whose query they match. The matching is done by postgres,
+// once for each distinct query, so it is the same as for search.
+func (s *ItemStream) dispatch(items []RssItemDto) {
+	if len(items) == 0 {
+		return
+	}
+	s.mu.Lock()
+	byQuery := make(map[streamQuery][]*streamSubscriber)
+	for subscriber := range s.subscribers {
+		query := streamQuery{tsQuery: subscriber.tsQuery, searchContent: subscriber.searchContent}
+		byQuery[query] = append(byQuery[query], subscriber)
+	}
+	s.mu.Unlock()
+	if len(byQuery) == 0 {
+		return
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ItemId
+	}
+	for query, subscribers := range byQuery {
+		matchingIds, err := s.service.repository.MatchItems(ids, query.tsQuery, query.searchContent)
+		if err != nil {
+			log.Printf("failed to match new items with query %v: %v", query.tsQuery, err)
+			continue
+		}
+		matching := make(map[string]bool, len(matchingIds))
+		for _, id := range matchingIds {
+			matching[id] = true
+		}
+		for _, item := range items {
+			if !matching[item.ItemId] {
+				continue
+			}
+			for _, subscriber := range subscribers {
+				select {
+				case subscriber.items <- item:
+				default:
+					log.Printf("stream subscriber with query %v is too slow, dropping item %v", query.tsQuery, item.ItemId)
+				}
+			}
+		}
+	}
+}